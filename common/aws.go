@@ -0,0 +1,36 @@
+package common
+
+import (
+	configapi "github.com/openshift/api/config/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Tokens resolving to the names of the AWS resources created by the OpenShift installer. The subnet
+// depends on the zone found in the providerSpec.
+var awsTokenResolver = platformTokenResolver{
+	platform: configapi.AWSPlatformType,
+	tokens: []string{
+		TokenAWSSubnet,
+		TokenAWSNodeSecurityGroup,
+		TokenAWSLBSecurityGroup,
+		TokenAWSWorkerInstanceProfile,
+		TokenAWSClusterTagKey,
+	},
+	resolve: func(obj *unstructured.Unstructured, cluster *ClusterInfo) map[string]string {
+		return awsTokens(cluster, ResolveZone(obj, cluster))
+	},
+}
+
+func awsTokens(cluster *ClusterInfo, zone string) map[string]string {
+	infrastructureName := cluster.InfrastructureName()
+	tokens := map[string]string{
+		TokenAWSNodeSecurityGroup:     infrastructureName + "-node",
+		TokenAWSLBSecurityGroup:       infrastructureName + "-lb",
+		TokenAWSWorkerInstanceProfile: infrastructureName + "-worker-profile",
+		TokenAWSClusterTagKey:         "kubernetes.io/cluster/" + infrastructureName,
+	}
+	if zone != "" {
+		tokens[TokenAWSSubnet] = infrastructureName + "-private-" + zone
+	}
+	return tokens
+}
@@ -0,0 +1,68 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	configapi "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestAWSTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	cluster := &ClusterInfo{
+		Infrastructure: configapi.InfrastructureStatus{
+			InfrastructureName: "mycluster-jfnx7",
+			PlatformStatus: &configapi.PlatformStatus{
+				Type: configapi.AWSPlatformType,
+				AWS:  &configapi.AWSPlatformStatus{Region: "us-east-2"},
+			},
+		},
+	}
+
+	assert.Equal(map[string]string{
+		"AWS_SUBNET":                  "mycluster-jfnx7-private-us-east-2a",
+		"AWS_NODE_SECURITY_GROUP":     "mycluster-jfnx7-node",
+		"AWS_LB_SECURITY_GROUP":       "mycluster-jfnx7-lb",
+		"AWS_WORKER_INSTANCE_PROFILE": "mycluster-jfnx7-worker-profile",
+		"AWS_CLUSTER_TAG_KEY":         "kubernetes.io/cluster/mycluster-jfnx7"}, awsTokens(cluster, "us-east-2a"))
+
+	assert.Equal("", awsTokens(cluster, "")[TokenAWSSubnet])
+}
+
+func TestResolveAWSTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	cluster := &ClusterInfo{
+		Infrastructure: configapi.InfrastructureStatus{
+			InfrastructureName: "mycluster-jfnx7",
+			PlatformStatus: &configapi.PlatformStatus{
+				Type: configapi.AWSPlatformType,
+				AWS:  &configapi.AWSPlatformStatus{Region: "us-east-2"},
+			},
+		},
+	}
+
+	// The zone is written using the REGION token
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machineSet.SetKind(KindMachineSet)
+	machineSet.SetAnnotations(map[string]string{AnnotationTokens: "AWS_SUBNET,AWS_NODE_SECURITY_GROUP"})
+	unstructured.SetNestedField(machineSet.Object, "REGIONb", "spec", "template", "spec", "providerSpec", "value", "placement", "availabilityZone")
+	resolverTokens, err := awsTokenResolver.ResolveTokens(context.TODO(), machineSet, cluster)
+	assert.Nil(err)
+	assert.Equal(map[string]string{
+		"INFRANAME":               "mycluster-jfnx7",
+		"AWS_SUBNET":              "mycluster-jfnx7-private-us-east-2b",
+		"AWS_NODE_SECURITY_GROUP": "mycluster-jfnx7-node"}, ResolveBuiltinTokens(logger, machineSet, "INFRANAME", cluster, resolverTokens))
+
+	// Machines carry the zone in their own providerSpec
+	machine := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machine.SetKind(KindMachine)
+	machine.SetAnnotations(map[string]string{AnnotationTokens: "AWS_SUBNET"})
+	unstructured.SetNestedField(machine.Object, "us-east-2c", "spec", "providerSpec", "value", "placement", "availabilityZone")
+	resolverTokens, err = awsTokenResolver.ResolveTokens(context.TODO(), machine, cluster)
+	assert.Nil(err)
+	assert.Equal("mycluster-jfnx7-private-us-east-2c", ResolveBuiltinTokens(logger, machine, "INFRANAME", cluster, resolverTokens)[TokenAWSSubnet])
+}
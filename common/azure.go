@@ -0,0 +1,57 @@
+package common
+
+import (
+	"strings"
+
+	configapi "github.com/openshift/api/config/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Tokens resolving to the names of the Azure resources created by the OpenShift installer.
+var azureTokenResolver = platformTokenResolver{
+	platform: configapi.AzurePlatformType,
+	tokens: []string{
+		TokenAzureResourceGroup,
+		TokenAzureNetworkResourceGroup,
+		TokenAzureVnet,
+		TokenAzureSubnet,
+		TokenAzureImage,
+		TokenAzureImageGen2,
+		TokenAzureManagedIdentity,
+	},
+	resolve: func(obj *unstructured.Unstructured, cluster *ClusterInfo) map[string]string {
+		return azureTokens(cluster)
+	},
+}
+
+func azureTokens(cluster *ClusterInfo) map[string]string {
+	infrastructureName := cluster.InfrastructureName()
+
+	// The resource groups are reported by the platform status, fall back to the installer defaults
+	resourceGroup := infrastructureName + "-rg"
+	networkResourceGroup := ""
+	if platformStatus := cluster.Infrastructure.PlatformStatus; platformStatus != nil && platformStatus.Azure != nil {
+		azure := platformStatus.Azure
+		if azure.ResourceGroupName != "" {
+			resourceGroup = azure.ResourceGroupName
+		}
+		networkResourceGroup = azure.NetworkResourceGroupName
+	}
+	if networkResourceGroup == "" {
+		networkResourceGroup = resourceGroup
+	}
+
+	// The installer uploads the RHCOS image into a gallery named after the infrastructure name
+	gallery := "/resourceGroups/" + resourceGroup + "/providers/Microsoft.Compute/galleries/gallery_" +
+		strings.ReplaceAll(infrastructureName, "-", "_") + "/images/"
+
+	return map[string]string{
+		TokenAzureResourceGroup:        resourceGroup,
+		TokenAzureNetworkResourceGroup: networkResourceGroup,
+		TokenAzureVnet:                 infrastructureName + "-vnet",
+		TokenAzureSubnet:               infrastructureName + "-worker-subnet",
+		TokenAzureImage:                gallery + infrastructureName + "/versions/latest",
+		TokenAzureImageGen2:            gallery + infrastructureName + "-gen2/versions/latest",
+		TokenAzureManagedIdentity:      infrastructureName + "-identity",
+	}
+}
@@ -0,0 +1,35 @@
+package common
+
+import (
+	"testing"
+
+	configapi "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAzureTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	cluster := &ClusterInfo{
+		Infrastructure: configapi.InfrastructureStatus{
+			InfrastructureName: "mycluster-jfnx7",
+			PlatformStatus: &configapi.PlatformStatus{
+				Type:  configapi.AzurePlatformType,
+				Azure: &configapi.AzurePlatformStatus{ResourceGroupName: "mycluster-jfnx7-rg"},
+			},
+		},
+	}
+
+	assert.Equal(map[string]string{
+		"AZURE_RESOURCE_GROUP":         "mycluster-jfnx7-rg",
+		"AZURE_NETWORK_RESOURCE_GROUP": "mycluster-jfnx7-rg",
+		"AZURE_VNET":                   "mycluster-jfnx7-vnet",
+		"AZURE_SUBNET":                 "mycluster-jfnx7-worker-subnet",
+		"AZURE_IMAGE":                  "/resourceGroups/mycluster-jfnx7-rg/providers/Microsoft.Compute/galleries/gallery_mycluster_jfnx7/images/mycluster-jfnx7/versions/latest",
+		"AZURE_IMAGE_GEN2":             "/resourceGroups/mycluster-jfnx7-rg/providers/Microsoft.Compute/galleries/gallery_mycluster_jfnx7/images/mycluster-jfnx7-gen2/versions/latest",
+		"AZURE_MANAGED_IDENTITY":       "mycluster-jfnx7-identity"}, azureTokens(cluster))
+
+	// Existing network in a separate resource group
+	cluster.Infrastructure.PlatformStatus.Azure.NetworkResourceGroupName = "shared-network-rg"
+	assert.Equal("shared-network-rg", azureTokens(cluster)[TokenAzureNetworkResourceGroup])
+}
@@ -0,0 +1,291 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	configapi "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	architectureX86_64  = "x86_64"
+	architectureAArch64 = "aarch64"
+
+	// How long the CoreOS stream metadata is reused before it is read again from the ConfigMap
+	coreOSStreamCacheTTL = 10 * time.Minute
+)
+
+// Instance types running on ARM processors. All other instance types are assumed to be x86_64.
+var aarch64InstanceTypes = map[configapi.PlatformType]*regexp.Regexp{
+	// For example m6g.large, c7gn.xlarge, a1.medium
+	configapi.AWSPlatformType: regexp.MustCompile(`^(a1|[a-z]+[0-9]+g[a-z]*)\.`),
+	// For example t2a-standard-4, c4a-standard-8
+	configapi.GCPPlatformType: regexp.MustCompile(`^(t2a|c4a)-`),
+	// For example Standard_D4ps_v5, Standard_E8pds_v5
+	configapi.AzurePlatformType: regexp.MustCompile(`^Standard_[A-Z]+[0-9]+[a-z]*p[a-z]*_v[0-9]+$`),
+}
+
+// Fields within the providerSpec value that hold the instance type
+var instanceTypeFields = map[configapi.PlatformType]string{
+	configapi.AWSPlatformType:   "instanceType",
+	configapi.GCPPlatformType:   "machineType",
+	configapi.AzurePlatformType: "vmSize",
+}
+
+type azureMarketplaceImage struct {
+	Publisher string `json:"publisher"`
+	Offer     string `json:"offer"`
+	SKU       string `json:"sku"`
+	Version   string `json:"version"`
+}
+
+// The parts of the CoreOS stream metadata published in the coreos-bootimages ConfigMap that are
+// needed to look up the boot images.
+type CoreOSStream struct {
+	Architectures map[string]struct {
+		Images struct {
+			AWS *struct {
+				Regions map[string]struct {
+					Image string `json:"image"`
+				} `json:"regions"`
+			} `json:"aws"`
+			GCP *struct {
+				Project string `json:"project"`
+				Name    string `json:"name"`
+			} `json:"gcp"`
+		} `json:"images"`
+		Extensions *struct {
+			Marketplace *struct {
+				Azure *struct {
+					NoPurchasePlan *struct {
+						HyperVGen2 *azureMarketplaceImage `json:"hyperVGen2"`
+					} `json:"no-purchase-plan"`
+				} `json:"azure"`
+			} `json:"marketplace"`
+		} `json:"rhel-coreos-extensions"`
+	} `json:"architectures"`
+}
+
+func IsBootImageEnabled(obj *unstructured.Unstructured) bool {
+	annotations := obj.GetAnnotations()
+	value, found := annotations[AnnotationBootImage]
+	return found && value == "true"
+}
+
+// Read the CoreOS stream metadata from the coreos-bootimages ConfigMap. Returns nil if the ConfigMap
+// doesn't exist on this cluster.
+func LoadCoreOSStream(ctx context.Context, logger logr.Logger, reader client.Reader) (*CoreOSStream, error) {
+	configMap := &corev1.ConfigMap{}
+	key := client.ObjectKey{Namespace: NamespaceOpenShiftMachineConfigOperator, Name: ConfigMapCoreOSBootImages}
+	err := reader.Get(ctx, key, configMap)
+	if apierrors.IsNotFound(err) {
+		logger.Info("ConfigMap \"" + key.String() + "\" not found. Cannot resolve the boot image.")
+		return nil, nil
+	} else if err != nil {
+		logger.Error(err, "Failed to retrieve ConfigMap \""+key.String()+"\".")
+		return nil, err
+	}
+
+	stream := &CoreOSStream{}
+	if err = json.Unmarshal([]byte(configMap.Data[ConfigMapKeyStream]), stream); err != nil {
+		logger.Error(err, "Failed to parse the CoreOS stream metadata found in ConfigMap \""+key.String()+"\".")
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Caches the CoreOS stream metadata so that it isn't read from the API server on every reconcile.
+// The zero value is ready to use.
+type CoreOSStreamCache struct {
+	mutex    sync.Mutex
+	stream   *CoreOSStream
+	loadedAt time.Time
+}
+
+// Return the cached CoreOS stream metadata, or read it again when it is older than the TTL. Returns
+// nil if the ConfigMap doesn't exist on this cluster.
+func (c *CoreOSStreamCache) Load(ctx context.Context, logger logr.Logger, reader client.Reader) (*CoreOSStream, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.loadedAt.IsZero() && time.Since(c.loadedAt) < coreOSStreamCacheTTL {
+		return c.stream, nil
+	}
+	stream, err := LoadCoreOSStream(ctx, logger, reader)
+	if err != nil {
+		return nil, err
+	}
+	c.stream = stream
+	c.loadedAt = time.Now()
+	return stream, nil
+}
+
+// Architecture of the instance type, x86_64 unless the instance type is known to run on ARM.
+func InstanceTypeArchitecture(platform configapi.PlatformType, instanceType string) string {
+	if pattern, found := aarch64InstanceTypes[platform]; found && pattern.MatchString(instanceType) {
+		return architectureAArch64
+	}
+	return architectureX86_64
+}
+
+// Fill in the RHCOS boot image into the providerSpec found in the MachineSet sections. The image is
+// looked up by the cluster platform and region and by the architecture of the instance type. Only an
+// image left empty in the MachineSet or written with tokens is set, a pinned image is kept. Returns
+// the image that has been set or an empty string if no image has been set.
+func SetBootImage(logger logr.Logger, machineSet *unstructured.Unstructured, section *unstructured.Unstructured, stream *CoreOSStream, cluster *ClusterInfo) string {
+	platform := configapi.PlatformType(cluster.PlatformType())
+	if !isBootImageReplaceable(platform, machineSet, section) {
+		logger.V(1).Info("Boot image is pinned in the MachineSet. Keeping it.")
+		return ""
+	}
+	instanceType, _, _ := unstructured.NestedString(section.UnstructuredContent(),
+		append(append([]string{}, providerSpecValueFields...), instanceTypeFields[platform])...)
+	architecture := InstanceTypeArchitecture(platform, instanceType)
+
+	arch, found := stream.Architectures[architecture]
+	if !found {
+		logger.Info("No boot images found for architecture \"" + architecture + "\".")
+		return ""
+	}
+
+	providerSpecValue, found, _ := unstructured.NestedMap(section.UnstructuredContent(), providerSpecValueFields...)
+	if !found {
+		logger.Info("MachineSet has no providerSpec. Cannot set the boot image.")
+		return ""
+	}
+
+	image := ""
+	switch platform {
+	case configapi.AWSPlatformType:
+		if arch.Images.AWS != nil {
+			image = arch.Images.AWS.Regions[cluster.Region()].Image
+		}
+		if image != "" {
+			providerSpecValue["ami"] = map[string]interface{}{"id": image}
+		}
+	case configapi.GCPPlatformType:
+		if arch.Images.GCP != nil && arch.Images.GCP.Name != "" {
+			image = "projects/" + arch.Images.GCP.Project + "/global/images/" + arch.Images.GCP.Name
+			setGCPBootDiskImage(providerSpecValue, image)
+		}
+	case configapi.AzurePlatformType:
+		if arch.Extensions != nil && arch.Extensions.Marketplace != nil && arch.Extensions.Marketplace.Azure != nil &&
+			arch.Extensions.Marketplace.Azure.NoPurchasePlan != nil && arch.Extensions.Marketplace.Azure.NoPurchasePlan.HyperVGen2 != nil {
+			marketplaceImage := arch.Extensions.Marketplace.Azure.NoPurchasePlan.HyperVGen2
+			image = strings.Join([]string{marketplaceImage.Publisher, marketplaceImage.Offer, marketplaceImage.SKU, marketplaceImage.Version}, ":")
+			providerSpecValue["image"] = map[string]interface{}{
+				"publisher":  marketplaceImage.Publisher,
+				"offer":      marketplaceImage.Offer,
+				"sku":        marketplaceImage.SKU,
+				"version":    marketplaceImage.Version,
+				"resourceID": "",
+			}
+		}
+	default:
+		logger.Info("Boot images are not supported on platform \"" + string(platform) + "\".")
+		return ""
+	}
+
+	if image == "" {
+		logger.Info("No boot image found for platform \"" + string(platform) + "\", region \"" + cluster.Region() + "\" and architecture \"" + architecture + "\".")
+		return ""
+	}
+	unstructured.SetNestedMap(section.UnstructuredContent(), providerSpecValue, providerSpecValueFields...)
+	logger.V(1).Info("Boot image set to \"" + image + "\".")
+	return image
+}
+
+// Check if the boot image may be set. It may be if it's empty in the MachineSet or if tokens have been
+// replaced in it.
+func isBootImageReplaceable(platform configapi.PlatformType, machineSet *unstructured.Unstructured, section *unstructured.Unstructured) bool {
+	original := bootImageField(platform, machineSet)
+	return isEmptyBootImage(original) || !reflect.DeepEqual(original, bootImageField(platform, section))
+}
+
+// Return the field of the providerSpec that holds the boot image, nil if not found.
+func bootImageField(platform configapi.PlatformType, obj *unstructured.Unstructured) interface{} {
+	providerSpecValue, _, _ := unstructured.NestedMap(obj.UnstructuredContent(), providerSpecValueFields...)
+	switch platform {
+	case configapi.AWSPlatformType:
+		return providerSpecValue["ami"]
+	case configapi.GCPPlatformType:
+		if bootDisk := findGCPBootDisk(providerSpecValue); bootDisk != nil {
+			return bootDisk["image"]
+		}
+	case configapi.AzurePlatformType:
+		return providerSpecValue["image"]
+	}
+	return nil
+}
+
+// Check if the boot image field holds no non-empty string.
+func isEmptyBootImage(field interface{}) bool {
+	switch value := field.(type) {
+	case string:
+		return value == ""
+	case map[string]interface{}:
+		for _, item := range value {
+			if !isEmptyBootImage(item) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		for _, item := range value {
+			if !isEmptyBootImage(item) {
+				return false
+			}
+		}
+		return true
+	}
+	return field == nil
+}
+
+// Find the boot disk, or the first disk if none is marked as the boot disk. Returns nil if there are
+// no disks.
+func findGCPBootDisk(providerSpecValue map[string]interface{}) map[string]interface{} {
+	disks, _ := providerSpecValue["disks"].([]interface{})
+	var bootDisk map[string]interface{}
+	for _, disk := range disks {
+		if diskMap, ok := disk.(map[string]interface{}); ok {
+			if boot, _ := diskMap["boot"].(bool); boot || bootDisk == nil {
+				bootDisk = diskMap
+			}
+		}
+	}
+	return bootDisk
+}
+
+// Set the image of the boot disk, or the first disk if none is marked as the boot disk.
+func setGCPBootDiskImage(providerSpecValue map[string]interface{}, image string) {
+	bootDisk := findGCPBootDisk(providerSpecValue)
+	if bootDisk == nil {
+		disks, _ := providerSpecValue["disks"].([]interface{})
+		bootDisk = map[string]interface{}{"boot": true}
+		providerSpecValue["disks"] = append(disks, bootDisk)
+	}
+	bootDisk["image"] = image
+}
+
+// Set the RHCOS boot image if enabled on the MachineSet. The stream metadata is read through the cache.
+func ResolveBootImage(ctx context.Context, logger logr.Logger, streams *CoreOSStreamCache, reader client.Reader, machineSet *unstructured.Unstructured, section *unstructured.Unstructured, cluster *ClusterInfo) error {
+	if !IsBootImageEnabled(machineSet) {
+		return nil
+	}
+	stream, err := streams.Load(ctx, logger, reader)
+	if err != nil || stream == nil {
+		return err
+	}
+	SetBootImage(logger, machineSet, section, stream, cluster)
+	return nil
+}
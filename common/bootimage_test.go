@@ -0,0 +1,166 @@
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	configapi "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testCoreOSStream = `{
+  "stream": "rhcos-4.10",
+  "architectures": {
+    "x86_64": {
+      "images": {
+        "aws": {"regions": {"us-east-2": {"release": "410.84", "image": "ami-x86"}}},
+        "gcp": {"release": "410.84", "project": "rhcos-cloud", "name": "rhcos-410-84-x86-64"}
+      },
+      "rhel-coreos-extensions": {
+        "marketplace": {"azure": {"no-purchase-plan": {"hyperVGen2": {
+          "publisher": "azureopenshift", "offer": "aro4", "sku": "aro_410", "version": "410.84.20220125"}}}}
+      }
+    },
+    "aarch64": {
+      "images": {
+        "aws": {"regions": {"us-east-2": {"release": "410.84", "image": "ami-arm"}}}
+      }
+    }
+  }
+}`
+
+func newTestBootImageSection(providerSpecValue map[string]interface{}) *unstructured.Unstructured {
+	section := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	unstructured.SetNestedMap(section.Object, providerSpecValue, providerSpecValueFields...)
+	return section
+}
+
+func TestInstanceTypeArchitecture(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("x86_64", InstanceTypeArchitecture(configapi.AWSPlatformType, "m5.large"))
+	assert.Equal("x86_64", InstanceTypeArchitecture(configapi.AWSPlatformType, "g4dn.xlarge"))
+	assert.Equal("aarch64", InstanceTypeArchitecture(configapi.AWSPlatformType, "m6g.large"))
+	assert.Equal("aarch64", InstanceTypeArchitecture(configapi.AWSPlatformType, "c7gn.xlarge"))
+	assert.Equal("aarch64", InstanceTypeArchitecture(configapi.AWSPlatformType, "a1.medium"))
+	assert.Equal("x86_64", InstanceTypeArchitecture(configapi.GCPPlatformType, "n1-standard-4"))
+	assert.Equal("aarch64", InstanceTypeArchitecture(configapi.GCPPlatformType, "t2a-standard-4"))
+	assert.Equal("x86_64", InstanceTypeArchitecture(configapi.AzurePlatformType, "Standard_D4s_v3"))
+	assert.Equal("aarch64", InstanceTypeArchitecture(configapi.AzurePlatformType, "Standard_D4ps_v5"))
+	assert.Equal("x86_64", InstanceTypeArchitecture(configapi.VSpherePlatformType, "whatever"))
+}
+
+func TestSetBootImage(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := fake.NewClientBuilder().WithObjects(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: NamespaceOpenShiftMachineConfigOperator, Name: ConfigMapCoreOSBootImages},
+			Data:       map[string]string{ConfigMapKeyStream: testCoreOSStream},
+		}).Build()
+	stream, err := LoadCoreOSStream(context.TODO(), logger, reader)
+	assert.Nil(err)
+	assert.NotNil(stream)
+
+	aws := &ClusterInfo{Infrastructure: configapi.InfrastructureStatus{PlatformStatus: &configapi.PlatformStatus{
+		Type: configapi.AWSPlatformType, AWS: &configapi.AWSPlatformStatus{Region: "us-east-2"}}}}
+
+	// A pinned image is kept
+	section := newTestBootImageSection(map[string]interface{}{"instanceType": "m5.large", "ami": map[string]interface{}{"id": "ami-pinned"}})
+	assert.Equal("", SetBootImage(logger, section.DeepCopy(), section, stream, aws))
+	ami, _, _ := unstructured.NestedString(section.Object, append(providerSpecValueFields, "ami", "id")...)
+	assert.Equal("ami-pinned", ami)
+
+	// An image written with tokens is replaced
+	machineSet := newTestBootImageSection(map[string]interface{}{"instanceType": "m5.large", "ami": map[string]interface{}{"id": "_AMI_"}})
+	section = newTestBootImageSection(map[string]interface{}{"instanceType": "m5.large", "ami": map[string]interface{}{"id": "ami-old"}})
+	assert.Equal("ami-x86", SetBootImage(logger, machineSet, section, stream, aws))
+	ami, _, _ = unstructured.NestedString(section.Object, append(providerSpecValueFields, "ami", "id")...)
+	assert.Equal("ami-x86", ami)
+
+	// An empty image is set
+	section = newTestBootImageSection(map[string]interface{}{"instanceType": "m5.large", "ami": map[string]interface{}{"id": ""}})
+	assert.Equal("ami-x86", SetBootImage(logger, section.DeepCopy(), section, stream, aws))
+
+	section = newTestBootImageSection(map[string]interface{}{"instanceType": "m6g.large"})
+	assert.Equal("ami-arm", SetBootImage(logger, section.DeepCopy(), section, stream, aws))
+
+	awsOtherRegion := &ClusterInfo{Infrastructure: configapi.InfrastructureStatus{PlatformStatus: &configapi.PlatformStatus{
+		Type: configapi.AWSPlatformType, AWS: &configapi.AWSPlatformStatus{Region: "eu-west-1"}}}}
+	section = newTestBootImageSection(map[string]interface{}{"instanceType": "m5.large"})
+	assert.Equal("", SetBootImage(logger, section.DeepCopy(), section, stream, awsOtherRegion))
+
+	gcp := &ClusterInfo{Infrastructure: configapi.InfrastructureStatus{PlatformStatus: &configapi.PlatformStatus{
+		Type: configapi.GCPPlatformType, GCP: &configapi.GCPPlatformStatus{Region: "us-central1"}}}}
+	section = newTestBootImageSection(map[string]interface{}{
+		"machineType": "n1-standard-4",
+		"disks":       []interface{}{map[string]interface{}{"boot": true, "image": "pinned"}}})
+	assert.Equal("", SetBootImage(logger, section.DeepCopy(), section, stream, gcp))
+	section = newTestBootImageSection(map[string]interface{}{
+		"machineType": "n1-standard-4",
+		"disks":       []interface{}{map[string]interface{}{"boot": true, "image": ""}}})
+	assert.Equal("projects/rhcos-cloud/global/images/rhcos-410-84-x86-64", SetBootImage(logger, section.DeepCopy(), section, stream, gcp))
+	disks, _, _ := unstructured.NestedSlice(section.Object, append(providerSpecValueFields, "disks")...)
+	assert.Equal("projects/rhcos-cloud/global/images/rhcos-410-84-x86-64", disks[0].(map[string]interface{})["image"])
+
+	azure := &ClusterInfo{Infrastructure: configapi.InfrastructureStatus{PlatformStatus: &configapi.PlatformStatus{
+		Type: configapi.AzurePlatformType}}}
+	section = newTestBootImageSection(map[string]interface{}{"vmSize": "Standard_D4s_v3",
+		"image": map[string]interface{}{"resourceID": "/resourceGroups/rg/providers/Microsoft.Compute/images/pinned"}})
+	assert.Equal("", SetBootImage(logger, section.DeepCopy(), section, stream, azure))
+	section = newTestBootImageSection(map[string]interface{}{"vmSize": "Standard_D4s_v3",
+		"image": map[string]interface{}{"resourceID": "", "sku": ""}})
+	assert.Equal("azureopenshift:aro4:aro_410:410.84.20220125", SetBootImage(logger, section.DeepCopy(), section, stream, azure))
+	sku, _, _ := unstructured.NestedString(section.Object, append(providerSpecValueFields, "image", "sku")...)
+	assert.Equal("aro_410", sku)
+}
+
+// Counts the reads to check the caching
+type countingReader struct {
+	client.Reader
+	gets int
+}
+
+func (r *countingReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	r.gets++
+	return r.Reader.Get(ctx, key, obj)
+}
+
+func TestCoreOSStreamCache(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := &countingReader{Reader: fake.NewClientBuilder().WithObjects(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: NamespaceOpenShiftMachineConfigOperator, Name: ConfigMapCoreOSBootImages},
+			Data:       map[string]string{ConfigMapKeyStream: testCoreOSStream},
+		}).Build()}
+
+	cache := &CoreOSStreamCache{}
+	stream, err := cache.Load(context.TODO(), logger, reader)
+	assert.Nil(err)
+	assert.NotNil(stream)
+	cached, err := cache.Load(context.TODO(), logger, reader)
+	assert.Nil(err)
+	assert.Same(stream, cached)
+	assert.Equal(1, reader.gets)
+
+	// The stream is read again once the cached one expires
+	cache.loadedAt = time.Now().Add(-coreOSStreamCacheTTL)
+	_, err = cache.Load(context.TODO(), logger, reader)
+	assert.Nil(err)
+	assert.Equal(2, reader.gets)
+}
+
+func TestLoadCoreOSStreamNotFound(t *testing.T) {
+	assert := assert.New(t)
+
+	stream, err := LoadCoreOSStream(context.TODO(), logger, fake.NewClientBuilder().Build())
+	assert.Nil(err)
+	assert.Nil(stream)
+}
@@ -0,0 +1,22 @@
+package common
+
+import (
+	"github.com/noseka1/gitops-friendly-machinesets-operator/resolvers"
+)
+
+// ClusterInfo is defined next to the token resolvers, they receive it.
+type ClusterInfo = resolvers.ClusterInfo
+
+// Values of all built-in tokens keyed by their default token names. The infrastructure name token is
+// keyed by DefaultTokenName, its actual name can be changed using the token-name annotation.
+func BuiltinTokens(c *ClusterInfo) map[string]string {
+	return map[string]string{
+		DefaultTokenName:  c.InfrastructureName(),
+		TokenRegion:       c.Region(),
+		TokenPlatform:     c.PlatformType(),
+		TokenClusterName:  c.ClusterName(),
+		TokenBaseDomain:   c.BaseDomain,
+		TokenAPIServerURL: c.APIServerURL(),
+		TokenClusterID:    c.ClusterID,
+	}
+}
@@ -0,0 +1,49 @@
+package common
+
+import (
+	"testing"
+
+	configapi "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterInfo(t *testing.T) {
+	assert := assert.New(t)
+
+	var cluster *ClusterInfo
+
+	cluster = &ClusterInfo{}
+	assert.Equal("", cluster.Region())
+	assert.Equal("", cluster.PlatformType())
+	assert.Equal("", cluster.ClusterName())
+
+	cluster = &ClusterInfo{
+		Infrastructure: configapi.InfrastructureStatus{
+			InfrastructureName: "mycluster-jfnx7",
+			Platform:           configapi.VSpherePlatformType,
+			APIServerURL:       "https://api.mycluster.example.com:6443",
+		},
+		BaseDomain: "mycluster.example.com",
+		ClusterID:  "2ad8b6a6-9a4c-4c1e-b6f6-6c9a1c3f1d2e",
+	}
+	assert.Equal(map[string]string{
+		"INFRANAME":    "mycluster-jfnx7",
+		"REGION":       "",
+		"PLATFORM":     "VSphere",
+		"CLUSTERNAME":  "mycluster",
+		"BASEDOMAIN":   "mycluster.example.com",
+		"APISERVERURL": "https://api.mycluster.example.com:6443",
+		"CLUSTERID":    "2ad8b6a6-9a4c-4c1e-b6f6-6c9a1c3f1d2e"}, BuiltinTokens(cluster))
+
+	cluster = &ClusterInfo{
+		Infrastructure: configapi.InfrastructureStatus{
+			Platform: configapi.GCPPlatformType,
+			PlatformStatus: &configapi.PlatformStatus{
+				Type: configapi.GCPPlatformType,
+				GCP:  &configapi.GCPPlatformStatus{ProjectID: "myproject", Region: "us-central1"},
+			},
+		},
+	}
+	assert.Equal("us-central1", cluster.Region())
+	assert.Equal("GCP", cluster.PlatformType())
+}
@@ -1,11 +1,75 @@
 package common
 
 const (
-	AnnotationBase      = "gitops-friendly-machinesets.redhat-cop.io"
-	AnnotationEnabled   = AnnotationBase + "/enabled"
-	AnnotationTokenName = AnnotationBase + "/token-name"
+	AnnotationBase          = "gitops-friendly-machinesets.redhat-cop.io"
+	AnnotationEnabled       = AnnotationBase + "/enabled"
+	AnnotationTokenName     = AnnotationBase + "/token-name"
+	AnnotationTokens        = AnnotationBase + "/tokens"
+	AnnotationTokensFrom    = AnnotationBase + "/tokens-from"
+	AnnotationTemplate      = AnnotationBase + "/template"
+	AnnotationIncludePaths  = AnnotationBase + "/include-paths"
+	AnnotationExcludePaths  = AnnotationBase + "/exclude-paths"
+	AnnotationTokenSyntax   = AnnotationBase + "/token-syntax"
+	AnnotationLiteralPaths  = AnnotationBase + "/literal-paths"
+	AnnotationInheritFields = AnnotationBase + "/inherit-fields"
+	AnnotationInheritFrom   = AnnotationBase + "/inherit-from"
+	AnnotationBootImage     = AnnotationBase + "/boot-image"
+	AnnotationFailureDomain = AnnotationBase + "/failure-domain"
+	// Generation of the MachineSet at which the controller last patched it
+	AnnotationPatchedGeneration = AnnotationBase + "/patched-generation"
 
-	DefaultTokenName = "INFRANAME"
+	DefaultTokenName  = "INFRANAME"
+	TokenRegion       = "REGION"
+	TokenPlatform     = "PLATFORM"
+	TokenClusterName  = "CLUSTERNAME"
+	TokenBaseDomain   = "BASEDOMAIN"
+	TokenAPIServerURL = "APISERVERURL"
+	TokenClusterID    = "CLUSTERID"
+
+	TokenAWSSubnet                = "AWS_SUBNET"
+	TokenAWSNodeSecurityGroup     = "AWS_NODE_SECURITY_GROUP"
+	TokenAWSLBSecurityGroup       = "AWS_LB_SECURITY_GROUP"
+	TokenAWSWorkerInstanceProfile = "AWS_WORKER_INSTANCE_PROFILE"
+	TokenAWSClusterTagKey         = "AWS_CLUSTER_TAG_KEY"
+
+	TokenAzureResourceGroup        = "AZURE_RESOURCE_GROUP"
+	TokenAzureNetworkResourceGroup = "AZURE_NETWORK_RESOURCE_GROUP"
+	TokenAzureVnet                 = "AZURE_VNET"
+	TokenAzureSubnet               = "AZURE_SUBNET"
+	TokenAzureImage                = "AZURE_IMAGE"
+	TokenAzureImageGen2            = "AZURE_IMAGE_GEN2"
+	TokenAzureManagedIdentity      = "AZURE_MANAGED_IDENTITY"
+
+	TokenGCPProjectID      = "GCP_PROJECT_ID"
+	TokenGCPNetwork        = "GCP_NETWORK"
+	TokenGCPSubnet         = "GCP_SUBNET"
+	TokenGCPServiceAccount = "GCP_SERVICE_ACCOUNT"
+	TokenGCPImage          = "GCP_IMAGE"
+
+	TokenOpenStackCloudName     = "OPENSTACK_CLOUD_NAME"
+	TokenOpenStackNetwork       = "OPENSTACK_NETWORK"
+	TokenOpenStackSubnet        = "OPENSTACK_SUBNET"
+	TokenOpenStackSecurityGroup = "OPENSTACK_SECURITY_GROUP"
+	TokenOpenStackServerGroup   = "OPENSTACK_SERVER_GROUP"
+	TokenOpenStackImage         = "OPENSTACK_IMAGE"
+	TokenOpenStackClusterIDTag  = "OPENSTACK_CLUSTER_ID_TAG"
+
+	TokenSyntaxBare   = "bare"
+	TokenSyntaxDollar = "dollar"
+	TokenSyntaxBraces = "braces"
+
+	TransformLower    = "lower"
+	TransformTruncate = "truncate"
+	TransformSanitize = "sanitize"
+
+	InheritGroupImage     = "image"
+	InheritGroupNetwork   = "network"
+	InheritGroupIdentity  = "identity"
+	InheritGroupWorkspace = "workspace"
+	InheritGroupTags      = "tags"
+
+	TokenSourceKindConfigMap = "configmap"
+	TokenSourceKindSecret    = "secret"
 
 	FieldName              = "name"
 	FieldNamespace         = "namespace"
@@ -13,17 +77,42 @@ const (
 	FieldTemplate          = "template"
 	FieldMetadata          = "metadata"
 	FieldLabels            = "labels"
+	FieldAnnotations       = "annotations"
+	FieldSelector          = "selector"
+	FieldMatchLabels       = "matchLabels"
+	FieldMatchExpressions  = "matchExpressions"
+	FieldKey               = "key"
+	FieldProviderSpec      = "providerSpec"
+	FieldValue             = "value"
 	FieldStatus            = "status"
 	FieldAvailableReplicas = "availableReplicas"
 	FieldReplicas          = "replicas"
 
-	LabelMachineRole = "machine.openshift.io/cluster-api-machine-role"
+	FieldObservedGeneration = "observedGeneration"
+	FieldNodeRef            = "nodeRef"
+
+	KindMachine    = "Machine"
+	KindMachineSet = "MachineSet"
+
+	LabelClusterAPICluster = "machine.openshift.io/cluster-api-cluster"
+	LabelMachineSet        = "machine.openshift.io/cluster-api-machineset"
+	LabelMachineRole       = "machine.openshift.io/cluster-api-machine-role"
+	LabelMachineType       = "machine.openshift.io/cluster-api-machine-type"
+	// Label that selects the MachineSets sent to the operator's webhooks
+	LabelEnabled = AnnotationBase + "/enabled"
 
 	MachineRoleWorker = "worker"
 
-	EventTypeNormal   = "Normal"
-	EventReasonDelete = "Delete"
-	EventReasonScale  = "Scale"
+	EventTypeNormal             = "Normal"
+	EventTypeWarning            = "Warning"
+	EventReasonDelete           = "Delete"
+	EventReasonScale            = "Scale"
+	EventReasonCordon           = "Cordon"
+	EventReasonDisruptionBudget = "DisruptionBudget"
+
+	NamespaceOpenShiftMachineApi            = "openshift-machine-api"
+	NamespaceOpenShiftMachineConfigOperator = "openshift-machine-config-operator"
 
-	NamespaceOpenShiftMachineApi = "openshift-machine-api"
+	ConfigMapCoreOSBootImages = "coreos-bootimages"
+	ConfigMapKeyStream        = "stream"
 )
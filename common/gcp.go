@@ -0,0 +1,42 @@
+package common
+
+import (
+	configapi "github.com/openshift/api/config/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Tokens resolving to the GCP project and the names of the GCP resources created by the OpenShift
+// installer.
+var gcpTokenResolver = platformTokenResolver{
+	platform: configapi.GCPPlatformType,
+	tokens: []string{
+		TokenGCPProjectID,
+		TokenGCPNetwork,
+		TokenGCPSubnet,
+		TokenGCPServiceAccount,
+		TokenGCPImage,
+	},
+	resolve: func(obj *unstructured.Unstructured, cluster *ClusterInfo) map[string]string {
+		return gcpTokens(cluster)
+	},
+}
+
+func gcpTokens(cluster *ClusterInfo) map[string]string {
+	infrastructureName := cluster.InfrastructureName()
+	tokens := map[string]string{
+		TokenGCPNetwork: infrastructureName + "-network",
+		TokenGCPSubnet:  infrastructureName + "-worker-subnet",
+	}
+
+	// The project is needed for the service account and the image
+	projectID := ""
+	if platformStatus := cluster.Infrastructure.PlatformStatus; platformStatus != nil && platformStatus.GCP != nil {
+		projectID = platformStatus.GCP.ProjectID
+	}
+	if projectID != "" {
+		tokens[TokenGCPProjectID] = projectID
+		tokens[TokenGCPServiceAccount] = infrastructureName + "-w@" + projectID + ".iam.gserviceaccount.com"
+		tokens[TokenGCPImage] = "projects/" + projectID + "/global/images/" + infrastructureName + "-rhcos-image"
+	}
+	return tokens
+}
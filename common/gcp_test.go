@@ -0,0 +1,29 @@
+package common
+
+import (
+	"testing"
+
+	configapi "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCPTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	cluster := &ClusterInfo{
+		Infrastructure: configapi.InfrastructureStatus{
+			InfrastructureName: "mycluster-jfnx7",
+			PlatformStatus: &configapi.PlatformStatus{
+				Type: configapi.GCPPlatformType,
+				GCP:  &configapi.GCPPlatformStatus{ProjectID: "myproject", Region: "us-central1"},
+			},
+		},
+	}
+
+	assert.Equal(map[string]string{
+		"GCP_PROJECT_ID":      "myproject",
+		"GCP_NETWORK":         "mycluster-jfnx7-network",
+		"GCP_SUBNET":          "mycluster-jfnx7-worker-subnet",
+		"GCP_SERVICE_ACCOUNT": "mycluster-jfnx7-w@myproject.iam.gserviceaccount.com",
+		"GCP_IMAGE":           "projects/myproject/global/images/mycluster-jfnx7-rhcos-image"}, gcpTokens(cluster))
+}
@@ -0,0 +1,49 @@
+package common
+
+import (
+	"reflect"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Generation of the MachineSet at which the controller last replaced the tokens in it. Returns zero
+// if the controller didn't record it.
+func PatchedGeneration(machineSet *unstructured.Unstructured) int64 {
+	generation, err := strconv.ParseInt(machineSet.GetAnnotations()[AnnotationPatchedGeneration], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return generation
+}
+
+// Record in the updated sections the generation that the MachineSet reaches once it is patched with
+// them. Changing the spec increments the generation, changing the metadata doesn't. The annotation is
+// written in the same patch as the sections, it never lags behind the patched template.
+func SetPatchedGeneration(machineSet *unstructured.Unstructured, section *unstructured.Unstructured) {
+	generation := machineSet.GetGeneration()
+	spec, _, _ := unstructured.NestedFieldNoCopy(machineSet.UnstructuredContent(), FieldSpec)
+	updatedSpec, _, _ := unstructured.NestedFieldNoCopy(section.UnstructuredContent(), FieldSpec)
+	if !reflect.DeepEqual(spec, updatedSpec) {
+		generation++
+	}
+
+	annotations := section.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[AnnotationPatchedGeneration] = strconv.FormatInt(generation, 10)
+	section.SetAnnotations(annotations)
+}
+
+// Check whether machine-api has observed the MachineSet template in which the tokens were replaced.
+// The current generation must be observed as well. The recorded generation is stale if the tokens were
+// replaced by the webhook afterwards.
+func IsPatchedGenerationObserved(machineSet *unstructured.Unstructured) bool {
+	observedGeneration, _, _ := unstructured.NestedInt64(machineSet.UnstructuredContent(), FieldStatus, FieldObservedGeneration)
+	patchedGeneration := PatchedGeneration(machineSet)
+	if patchedGeneration < machineSet.GetGeneration() {
+		patchedGeneration = machineSet.GetGeneration()
+	}
+	return observedGeneration >= patchedGeneration
+}
@@ -0,0 +1,61 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestIsPatchedGenerationObserved(t *testing.T) {
+	assert := assert.New(t)
+
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machineSet.SetGeneration(3)
+	assert.Equal(int64(0), PatchedGeneration(machineSet))
+	assert.False(IsPatchedGenerationObserved(machineSet))
+
+	// Without the recorded generation, the current generation must be observed
+	unstructured.SetNestedField(machineSet.Object, int64(2), FieldStatus, FieldObservedGeneration)
+	assert.False(IsPatchedGenerationObserved(machineSet))
+	unstructured.SetNestedField(machineSet.Object, int64(3), FieldStatus, FieldObservedGeneration)
+	assert.True(IsPatchedGenerationObserved(machineSet))
+
+	// The generation at which the tokens were replaced must be observed
+	machineSet.SetAnnotations(map[string]string{AnnotationPatchedGeneration: "4"})
+	assert.Equal(int64(4), PatchedGeneration(machineSet))
+	assert.False(IsPatchedGenerationObserved(machineSet))
+	unstructured.SetNestedField(machineSet.Object, int64(4), FieldStatus, FieldObservedGeneration)
+	assert.True(IsPatchedGenerationObserved(machineSet))
+
+	// The recorded generation is stale, the current generation must be observed
+	machineSet.SetGeneration(5)
+	assert.False(IsPatchedGenerationObserved(machineSet))
+	unstructured.SetNestedField(machineSet.Object, int64(5), FieldStatus, FieldObservedGeneration)
+	assert.True(IsPatchedGenerationObserved(machineSet))
+
+	// An invalid annotation is ignored
+	machineSet.SetAnnotations(map[string]string{AnnotationPatchedGeneration: "invalid"})
+	unstructured.SetNestedField(machineSet.Object, int64(4), FieldStatus, FieldObservedGeneration)
+	assert.False(IsPatchedGenerationObserved(machineSet))
+}
+
+func TestSetPatchedGeneration(t *testing.T) {
+	assert := assert.New(t)
+
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machineSet.SetGeneration(3)
+	unstructured.SetNestedField(machineSet.Object, "INFRANAME-worker", FieldSpec, FieldTemplate, FieldMetadata, FieldLabels, "name")
+
+	// Patching the metadata doesn't increment the generation
+	section := ExtractObjectSections(machineSet)
+	section.SetLabels(map[string]string{"name": "mycluster-worker"})
+	SetPatchedGeneration(machineSet, section)
+	assert.Equal("3", section.GetAnnotations()[AnnotationPatchedGeneration])
+
+	// Patching the spec does
+	unstructured.SetNestedField(section.Object, "mycluster-worker", FieldSpec, FieldTemplate, FieldMetadata, FieldLabels, "name")
+	SetPatchedGeneration(machineSet, section)
+	assert.Equal("4", section.GetAnnotations()[AnnotationPatchedGeneration])
+	assert.Empty(machineSet.GetAnnotations())
+}
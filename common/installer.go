@@ -0,0 +1,189 @@
+package common
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-logr/logr"
+	machineapi "github.com/openshift/api/machine/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Groups of fields that can be inherited from the installer-provisioned MachineSet. The fields
+// are relative to spec.template.spec.providerSpec.value. Fields that the installer-provisioned
+// MachineSet doesn't define are skipped, so a group can cover the fields of all platforms.
+var inheritFieldGroups = map[string][]string{
+	InheritGroupImage:     {"ami", "image", "template"},
+	InheritGroupNetwork:   {"subnet", "securityGroups", "network", "networkInterfaces", "vnet", "networkResourceGroup"},
+	InheritGroupIdentity:  {"iamInstanceProfile", "serviceAccounts", "managedIdentity"},
+	InheritGroupWorkspace: {"workspace"},
+	InheritGroupTags:      {"tags"},
+}
+
+// Fields within the providerSpec value that hold the zone the Machines are placed in
+var providerSpecZoneFields = [][]string{
+	{"placement", "availabilityZone"},
+	{"zone"},
+	{"availabilityZone"},
+}
+
+var providerSpecValueFields = []string{FieldSpec, FieldTemplate, FieldSpec, FieldProviderSpec, FieldValue}
+
+var machineProviderSpecValueFields = []string{FieldSpec, FieldProviderSpec, FieldValue}
+
+func IsWorkerMachineSet(machineSet *unstructured.Unstructured) bool {
+	role, _, _ := unstructured.NestedFieldNoCopy(machineSet.UnstructuredContent(), FieldSpec, FieldTemplate, FieldMetadata, FieldLabels, LabelMachineRole)
+	roleString, ok := role.(string)
+	return ok && roleString == MachineRoleWorker
+}
+
+func NameStartsWith(machineSet *unstructured.Unstructured, prefix string) bool {
+	name := machineSet.GetName()
+	return strings.HasPrefix(name, prefix)
+}
+
+// The installer-provisioned worker MachineSets are named after the infrastructure name and are
+// not managed by this operator.
+func IsInstallerProvisionedMachineSet(machineSet *unstructured.Unstructured, infrastructureName string) bool {
+	return IsWorkerMachineSet(machineSet) &&
+		NameStartsWith(machineSet, infrastructureName) &&
+		!IsObjectReconciliationEnabled(machineSet)
+}
+
+func NewMachineSetUnstructuredList() *unstructured.UnstructuredList {
+	machineSetList := &unstructured.UnstructuredList{Object: map[string]interface{}{}}
+	machineSetList.SetGroupVersionKind(machineapi.SchemeGroupVersion.WithKind(KindMachineSet))
+	return machineSetList
+}
+
+// Look up all the installer-provisioned MachineSets in the openshift-machine-api namespace.
+func ListInstallerMachineSets(ctx context.Context, logger logr.Logger, reader client.Reader, infrastructureName string) ([]unstructured.Unstructured, error) {
+	allMachineSetsInNamespace := NewMachineSetUnstructuredList()
+	err := reader.List(ctx, allMachineSetsInNamespace, &client.ListOptions{Namespace: NamespaceOpenShiftMachineApi})
+	if err != nil {
+		logger.Error(err, "Failed to retrieve MachineSets from namespace "+NamespaceOpenShiftMachineApi)
+		return nil, err
+	}
+
+	installerMachineSets := []unstructured.Unstructured{}
+	for _, machineSet := range allMachineSetsInNamespace.Items {
+		if IsInstallerProvisionedMachineSet(&machineSet, infrastructureName) {
+			installerMachineSets = append(installerMachineSets, machineSet)
+		}
+	}
+	return installerMachineSets, nil
+}
+
+// Parse the inherit-fields annotation. The annotation holds a comma-separated list of field groups
+// or dot-separated field paths relative to the providerSpec value, for example "image,network,placement.region".
+func ParseInheritFields(obj *unstructured.Unstructured) [][]string {
+	fieldsString, found := obj.GetAnnotations()[AnnotationInheritFields]
+	if !found {
+		return nil
+	}
+
+	fields := [][]string{}
+	for _, name := range strings.Split(fieldsString, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if group, isGroup := inheritFieldGroups[name]; isGroup {
+			for _, field := range group {
+				fields = append(fields, []string{field})
+			}
+			continue
+		}
+		fields = append(fields, strings.Split(name, "."))
+	}
+	return fields
+}
+
+// Path to the providerSpec value of the MachineSet or Machine.
+func providerSpecValuePath(obj *unstructured.Unstructured) []string {
+	if obj.GetKind() == KindMachine {
+		return machineProviderSpecValueFields
+	}
+	return providerSpecValueFields
+}
+
+// Zone of the MachineSet or Machine as found in its providerSpec. Not all platforms define a zone.
+func ProviderSpecZone(obj *unstructured.Unstructured) string {
+	valueFields := providerSpecValuePath(obj)
+	for _, zoneField := range providerSpecZoneFields {
+		fields := append(append([]string{}, valueFields...), zoneField...)
+		if zone, found, _ := unstructured.NestedString(obj.UnstructuredContent(), fields...); found && zone != "" {
+			return zone
+		}
+	}
+	return ""
+}
+
+// Find the installer-provisioned MachineSet to inherit the fields from. The MachineSet named in the
+// inherit-from annotation wins. Otherwise the MachineSet placed in the same zone is chosen. If the
+// platform doesn't use zones, the single installer-provisioned MachineSet is chosen.
+func FindInstallerMachineSet(logger logr.Logger, machineSet *unstructured.Unstructured, installerMachineSets []unstructured.Unstructured) *unstructured.Unstructured {
+	if name, found := machineSet.GetAnnotations()[AnnotationInheritFrom]; found {
+		for i := range installerMachineSets {
+			if installerMachineSets[i].GetName() == name {
+				return &installerMachineSets[i]
+			}
+		}
+		logger.Info("Installer-provisioned MachineSet \"" + name + "\" listed in annotation \"" + AnnotationInheritFrom + "\" not found.")
+		return nil
+	}
+
+	zone := ProviderSpecZone(machineSet)
+	if zone == "" {
+		if len(installerMachineSets) == 1 {
+			return &installerMachineSets[0]
+		}
+		logger.Info("MachineSet has no zone and there isn't exactly one installer-provisioned MachineSet to inherit from.")
+		return nil
+	}
+	for i := range installerMachineSets {
+		if ProviderSpecZone(&installerMachineSets[i]) == zone {
+			return &installerMachineSets[i]
+		}
+	}
+	logger.Info("No installer-provisioned MachineSet found in zone \"" + zone + "\".")
+	return nil
+}
+
+// Copy the fields listed in the inherit-fields annotation from the installer-provisioned MachineSet into
+// the providerSpec found in the MachineSet sections. Fields already set in the sections are left untouched.
+// Returns the names of the inherited fields.
+func InheritInstallerFields(logger logr.Logger, machineSet *unstructured.Unstructured, section *unstructured.Unstructured, installerMachineSets []unstructured.Unstructured) []string {
+	fields := ParseInheritFields(machineSet)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	installerMachineSet := FindInstallerMachineSet(logger, section, installerMachineSets)
+	if installerMachineSet == nil {
+		return nil
+	}
+
+	inherited := []string{}
+	for _, field := range fields {
+		fieldPath := append(append([]string{}, providerSpecValueFields...), field...)
+		if _, found, _ := unstructured.NestedFieldNoCopy(section.UnstructuredContent(), fieldPath...); found {
+			continue
+		}
+		value, found, _ := unstructured.NestedFieldNoCopy(installerMachineSet.UnstructuredContent(), fieldPath...)
+		if !found {
+			continue
+		}
+		if err := unstructured.SetNestedField(section.UnstructuredContent(), value, fieldPath...); err != nil {
+			logger.Error(err, "Failed to inherit field \""+strings.Join(field, ".")+"\".")
+			continue
+		}
+		inherited = append(inherited, strings.Join(field, "."))
+	}
+
+	if len(inherited) > 0 {
+		logger.Info("Fields \"" + strings.Join(inherited, ", ") + "\" inherited from installer-provisioned MachineSet \"" + installerMachineSet.GetName() + "\".")
+	}
+	return inherited
+}
@@ -0,0 +1,134 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	machineapi "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestMachineSet(name string, zone string) *unstructured.Unstructured {
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machineSet.SetGroupVersionKind(machineapi.SchemeGroupVersion.WithKind(KindMachineSet))
+	machineSet.SetNamespace(NamespaceOpenShiftMachineApi)
+	machineSet.SetName(name)
+	unstructured.SetNestedField(machineSet.Object, MachineRoleWorker, FieldSpec, FieldTemplate, FieldMetadata, FieldLabels, LabelMachineRole)
+	if zone != "" {
+		unstructured.SetNestedField(machineSet.Object, zone, FieldSpec, FieldTemplate, FieldSpec, FieldProviderSpec, FieldValue, "placement", "availabilityZone")
+	}
+	return machineSet
+}
+
+func TestIsWorkerMachineSet(t *testing.T) {
+	assert := assert.New(t)
+
+	var machineSet *unstructured.Unstructured
+
+	machineSet = &unstructured.Unstructured{}
+	assert.Equal(false, IsWorkerMachineSet(machineSet))
+
+	machineSet = &unstructured.Unstructured{Object: map[string]interface{}{}}
+	unstructured.SetNestedField(machineSet.UnstructuredContent(), "worker", "spec", "template", "metadata", "labels", "machine.openshift.io/cluster-api-machine-role")
+	assert.Equal(true, IsWorkerMachineSet(machineSet))
+}
+
+func TestNameStartsWith(t *testing.T) {
+	assert := assert.New(t)
+
+	var machineSet *unstructured.Unstructured
+
+	machineSet = &unstructured.Unstructured{}
+	assert.Equal(false, NameStartsWith(machineSet, "mycluster"))
+
+	machineSet = &unstructured.Unstructured{Object: map[string]interface{}{}}
+	unstructured.SetNestedField(machineSet.UnstructuredContent(), "mycluster", "metadata", "name")
+	assert.Equal(false, NameStartsWith(machineSet, "myprefix"))
+	assert.Equal(true, NameStartsWith(machineSet, "my"))
+	assert.Equal(true, NameStartsWith(machineSet, "mycluster"))
+}
+
+func TestListInstallerMachineSets(t *testing.T) {
+	assert := assert.New(t)
+
+	scheme := runtime.NewScheme()
+	machineapi.AddToScheme(scheme)
+
+	managed := newTestMachineSet("mycluster-managed", "us-east-2a")
+	managed.SetAnnotations(map[string]string{AnnotationEnabled: "true"})
+
+	// The fake client stores typed MachineSets
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for _, obj := range []*unstructured.Unstructured{
+		newTestMachineSet("mycluster-worker-us-east-2a", "us-east-2a"),
+		newTestMachineSet("other-worker-us-east-2a", "us-east-2a"),
+		managed,
+	} {
+		machineSet := &machineapi.MachineSet{}
+		assert.Nil(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, machineSet))
+		builder = builder.WithObjects(machineSet)
+	}
+	reader := builder.Build()
+
+	machineSets, err := ListInstallerMachineSets(context.TODO(), logger, reader, "mycluster")
+	assert.Nil(err)
+	assert.Len(machineSets, 1)
+	assert.Equal("mycluster-worker-us-east-2a", machineSets[0].GetName())
+}
+
+func TestParseInheritFields(t *testing.T) {
+	assert := assert.New(t)
+
+	input := &unstructured.Unstructured{}
+	assert.Nil(ParseInheritFields(input))
+
+	input.SetAnnotations(map[string]string{AnnotationInheritFields: "identity, placement.region,"})
+	assert.Equal([][]string{
+		{"iamInstanceProfile"}, {"serviceAccounts"}, {"managedIdentity"},
+		{"placement", "region"}}, ParseInheritFields(input))
+}
+
+func TestInheritInstallerFields(t *testing.T) {
+	assert := assert.New(t)
+
+	installerA := newTestMachineSet("mycluster-worker-us-east-2a", "us-east-2a")
+	unstructured.SetNestedField(installerA.Object, "ami-a", FieldSpec, FieldTemplate, FieldSpec, FieldProviderSpec, FieldValue, "ami", "id")
+	unstructured.SetNestedField(installerA.Object, "profile-a", FieldSpec, FieldTemplate, FieldSpec, FieldProviderSpec, FieldValue, "iamInstanceProfile", "id")
+	installerB := newTestMachineSet("mycluster-worker-us-east-2b", "us-east-2b")
+	unstructured.SetNestedField(installerB.Object, "ami-b", FieldSpec, FieldTemplate, FieldSpec, FieldProviderSpec, FieldValue, "ami", "id")
+	installerMachineSets := []unstructured.Unstructured{*installerA, *installerB}
+
+	// Matched by zone, fields set in the MachineSet are kept
+	machineSet := newTestMachineSet("managed", "us-east-2b")
+	machineSet.SetAnnotations(map[string]string{AnnotationInheritFields: "image,identity"})
+	unstructured.SetNestedField(machineSet.Object, "own-profile", FieldSpec, FieldTemplate, FieldSpec, FieldProviderSpec, FieldValue, "iamInstanceProfile", "id")
+	section := ExtractObjectSections(machineSet)
+	assert.Equal([]string{"ami"}, InheritInstallerFields(logger, machineSet, section, installerMachineSets))
+	ami, _, _ := unstructured.NestedString(section.Object, FieldSpec, FieldTemplate, FieldSpec, FieldProviderSpec, FieldValue, "ami", "id")
+	assert.Equal("ami-b", ami)
+	profile, _, _ := unstructured.NestedString(section.Object, FieldSpec, FieldTemplate, FieldSpec, FieldProviderSpec, FieldValue, "iamInstanceProfile", "id")
+	assert.Equal("own-profile", profile)
+
+	// Matched explicitly by name
+	machineSet = newTestMachineSet("managed", "us-east-2b")
+	machineSet.SetAnnotations(map[string]string{
+		AnnotationInheritFields: "ami",
+		AnnotationInheritFrom:   "mycluster-worker-us-east-2a"})
+	section = ExtractObjectSections(machineSet)
+	assert.Equal([]string{"ami"}, InheritInstallerFields(logger, machineSet, section, installerMachineSets))
+	ami, _, _ = unstructured.NestedString(section.Object, FieldSpec, FieldTemplate, FieldSpec, FieldProviderSpec, FieldValue, "ami", "id")
+	assert.Equal("ami-a", ami)
+
+	// No installer-provisioned MachineSet in the zone
+	machineSet = newTestMachineSet("managed", "us-east-2c")
+	machineSet.SetAnnotations(map[string]string{AnnotationInheritFields: "image"})
+	assert.Empty(InheritInstallerFields(logger, machineSet, ExtractObjectSections(machineSet), installerMachineSets))
+
+	// Without zones, the single installer-provisioned MachineSet is used
+	machineSet = newTestMachineSet("managed", "")
+	machineSet.SetAnnotations(map[string]string{AnnotationInheritFields: "image"})
+	assert.Equal([]string{"ami"}, InheritInstallerFields(logger, machineSet, ExtractObjectSections(machineSet), installerMachineSets[:1]))
+}
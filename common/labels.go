@@ -0,0 +1,68 @@
+package common
+
+import (
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Set the label unless it is already present. Returns true if the label has been added.
+func defaultLabel(section *unstructured.Unstructured, key, value string, fields ...string) bool {
+	if value == "" {
+		return false
+	}
+	labels, _, _ := unstructured.NestedStringMap(section.UnstructuredContent(), fields...)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	if _, found := labels[key]; found {
+		return false
+	}
+	labels[key] = value
+	unstructured.SetNestedStringMap(section.UnstructuredContent(), labels, fields...)
+	return true
+}
+
+// Add the missing machine-api labels to the MachineSet sections. The cluster label is added to the
+// MachineSet labels, the selector and the template labels. The MachineSet label is added to the
+// selector and the template labels. The role and type labels default to each other in the template
+// labels. The selector and the template labels are only defaulted when the MachineSet is created,
+// changing the selector of an existing MachineSet would orphan its Machines. Returns the number of
+// labels added.
+func DefaultMachineAPILabels(logger logr.Logger, section *unstructured.Unstructured, machineSetName string, infrastructureName string, create bool) int {
+	added := 0
+	if defaultLabel(section, LabelClusterAPICluster, infrastructureName, FieldMetadata, FieldLabels) {
+		added++
+	}
+	if create {
+		added += defaultSelectorAndTemplateLabels(section, machineSetName, infrastructureName)
+	}
+
+	if added > 0 {
+		logger.V(1).Info("Added missing machine-api labels to MachineSet.")
+	}
+	return added
+}
+
+func defaultSelectorAndTemplateLabels(section *unstructured.Unstructured, machineSetName string, infrastructureName string) int {
+	selectorLabels := []string{FieldSpec, FieldSelector, FieldMatchLabels}
+	templateLabels := []string{FieldSpec, FieldTemplate, FieldMetadata, FieldLabels}
+
+	added := 0
+	for _, fields := range [][]string{selectorLabels, templateLabels} {
+		if defaultLabel(section, LabelClusterAPICluster, infrastructureName, fields...) {
+			added++
+		}
+		if defaultLabel(section, LabelMachineSet, machineSetName, fields...) {
+			added++
+		}
+	}
+
+	labels, _, _ := unstructured.NestedStringMap(section.UnstructuredContent(), templateLabels...)
+	if defaultLabel(section, LabelMachineRole, labels[LabelMachineType], templateLabels...) {
+		added++
+	}
+	if defaultLabel(section, LabelMachineType, labels[LabelMachineRole], templateLabels...) {
+		added++
+	}
+	return added
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDefaultMachineAPILabels(t *testing.T) {
+	assert := assert.New(t)
+
+	section := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	unstructured.SetNestedStringMap(section.Object, map[string]string{LabelMachineRole: "infra"},
+		"spec", "template", "metadata", "labels")
+
+	assert.Equal(6, DefaultMachineAPILabels(logger, section, "mymachineset", "mycluster-jfnx7", true))
+	assert.Equal(map[string]string{LabelClusterAPICluster: "mycluster-jfnx7"}, section.GetLabels())
+	selector, _, _ := unstructured.NestedStringMap(section.Object, "spec", "selector", "matchLabels")
+	assert.Equal(map[string]string{
+		LabelClusterAPICluster: "mycluster-jfnx7",
+		LabelMachineSet:        "mymachineset"}, selector)
+	templateLabels, _, _ := unstructured.NestedStringMap(section.Object, "spec", "template", "metadata", "labels")
+	assert.Equal(map[string]string{
+		LabelClusterAPICluster: "mycluster-jfnx7",
+		LabelMachineSet:        "mymachineset",
+		LabelMachineRole:       "infra",
+		LabelMachineType:       "infra"}, templateLabels)
+
+	// Existing labels are kept, the role is not invented
+	section = &unstructured.Unstructured{Object: map[string]interface{}{}}
+	section.SetLabels(map[string]string{LabelClusterAPICluster: "other"})
+	assert.Equal(4, DefaultMachineAPILabels(logger, section, "mymachineset", "mycluster-jfnx7", true))
+	assert.Equal(map[string]string{LabelClusterAPICluster: "other"}, section.GetLabels())
+	templateLabels, _, _ = unstructured.NestedStringMap(section.Object, "spec", "template", "metadata", "labels")
+	assert.NotContains(templateLabels, LabelMachineRole)
+
+	// Nothing to add
+	assert.Equal(0, DefaultMachineAPILabels(logger, section, "mymachineset", "mycluster-jfnx7", true))
+
+	// An existing MachineSet only gets the MachineSet labels, its selector is left unchanged
+	section = &unstructured.Unstructured{Object: map[string]interface{}{}}
+	unstructured.SetNestedStringMap(section.Object, map[string]string{"app": "worker"},
+		"spec", "selector", "matchLabels")
+	assert.Equal(1, DefaultMachineAPILabels(logger, section, "mymachineset", "mycluster-jfnx7", false))
+	assert.Equal(map[string]string{LabelClusterAPICluster: "mycluster-jfnx7"}, section.GetLabels())
+	selector, _, _ = unstructured.NestedStringMap(section.Object, "spec", "selector", "matchLabels")
+	assert.Equal(map[string]string{"app": "worker"}, selector)
+	_, found, _ := unstructured.NestedFieldNoCopy(section.Object, "spec", "template")
+	assert.False(found)
+}
@@ -0,0 +1,45 @@
+package common
+
+import (
+	configapi "github.com/openshift/api/config/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const defaultOpenStackCloudName = "openstack"
+
+// Tokens resolving to the names of the OpenStack resources created by the OpenShift installer.
+var openStackTokenResolver = platformTokenResolver{
+	platform: configapi.OpenStackPlatformType,
+	tokens: []string{
+		TokenOpenStackCloudName,
+		TokenOpenStackNetwork,
+		TokenOpenStackSubnet,
+		TokenOpenStackSecurityGroup,
+		TokenOpenStackServerGroup,
+		TokenOpenStackImage,
+		TokenOpenStackClusterIDTag,
+	},
+	resolve: func(obj *unstructured.Unstructured, cluster *ClusterInfo) map[string]string {
+		return openStackTokens(cluster)
+	},
+}
+
+func openStackTokens(cluster *ClusterInfo) map[string]string {
+	// The cloud in clouds.yaml is reported by the platform status, fall back to the installer default
+	cloudName := defaultOpenStackCloudName
+	if platformStatus := cluster.Infrastructure.PlatformStatus; platformStatus != nil &&
+		platformStatus.OpenStack != nil && platformStatus.OpenStack.CloudName != "" {
+		cloudName = platformStatus.OpenStack.CloudName
+	}
+
+	infrastructureName := cluster.InfrastructureName()
+	return map[string]string{
+		TokenOpenStackCloudName:     cloudName,
+		TokenOpenStackNetwork:       infrastructureName + "-openshift",
+		TokenOpenStackSubnet:        infrastructureName + "-nodes",
+		TokenOpenStackSecurityGroup: infrastructureName + "-worker",
+		TokenOpenStackServerGroup:   infrastructureName + "-worker",
+		TokenOpenStackImage:         infrastructureName + "-rhcos",
+		TokenOpenStackClusterIDTag:  "openshiftClusterID=" + infrastructureName,
+	}
+}
@@ -0,0 +1,34 @@
+package common
+
+import (
+	"testing"
+
+	configapi "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenStackTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	cluster := &ClusterInfo{
+		Infrastructure: configapi.InfrastructureStatus{
+			InfrastructureName: "mycluster-jfnx7",
+			PlatformStatus: &configapi.PlatformStatus{
+				Type:      configapi.OpenStackPlatformType,
+				OpenStack: &configapi.OpenStackPlatformStatus{},
+			},
+		},
+	}
+
+	assert.Equal(map[string]string{
+		"OPENSTACK_CLOUD_NAME":     "openstack",
+		"OPENSTACK_NETWORK":        "mycluster-jfnx7-openshift",
+		"OPENSTACK_SUBNET":         "mycluster-jfnx7-nodes",
+		"OPENSTACK_SECURITY_GROUP": "mycluster-jfnx7-worker",
+		"OPENSTACK_SERVER_GROUP":   "mycluster-jfnx7-worker",
+		"OPENSTACK_IMAGE":          "mycluster-jfnx7-rhcos",
+		"OPENSTACK_CLUSTER_ID_TAG": "openshiftClusterID=mycluster-jfnx7"}, openStackTokens(cluster))
+
+	cluster.Infrastructure.PlatformStatus.OpenStack.CloudName = "mycloud"
+	assert.Equal("mycloud", openStackTokens(cluster)[TokenOpenStackCloudName])
+}
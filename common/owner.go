@@ -0,0 +1,130 @@
+package common
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-logr/logr"
+	machineapi "github.com/openshift/api/machine/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Annotations whose paths are relative to the MachineSet. They are converted to the Machine paths when
+// inherited.
+var pathAnnotations = map[string]bool{
+	AnnotationIncludePaths: true,
+	AnnotationExcludePaths: true,
+	AnnotationLiteralPaths: true,
+}
+
+// Reference to the MachineSet that controls the object, nil if the object isn't controlled by a MachineSet.
+func OwnerMachineSetReference(obj *unstructured.Unstructured) *metav1.OwnerReference {
+	ownerRef := metav1.GetControllerOf(obj)
+	if ownerRef == nil || ownerRef.Kind != KindMachineSet {
+		return nil
+	}
+	gv, err := schema.ParseGroupVersion(ownerRef.APIVersion)
+	if err != nil || gv.Group != machineapi.SchemeGroupVersion.Group {
+		return nil
+	}
+	return ownerRef
+}
+
+// Look up the MachineSet that owns the Machine. Returns nil if the Machine isn't owned by a MachineSet
+// or if the MachineSet doesn't exist anymore.
+func GetOwnerMachineSet(ctx context.Context, logger logr.Logger, reader client.Reader, machine *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	ownerRef := OwnerMachineSetReference(machine)
+	if ownerRef == nil {
+		return nil, nil
+	}
+
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machineSet.SetGroupVersionKind(machineapi.SchemeGroupVersion.WithKind(KindMachineSet))
+	err := reader.Get(ctx, client.ObjectKey{Namespace: machine.GetNamespace(), Name: ownerRef.Name}, machineSet)
+	if apierrors.IsNotFound(err) {
+		logger.V(1).Info("Owner MachineSet \"" + ownerRef.Name + "\" not found.")
+		return nil, nil
+	} else if err != nil {
+		logger.Error(err, "Failed to retrieve the owner MachineSet \""+ownerRef.Name+"\".")
+		return nil, err
+	}
+
+	// The MachineSet was re-created under the same name, it doesn't own this Machine
+	if machineSet.GetUID() != ownerRef.UID {
+		return nil, nil
+	}
+	return machineSet, nil
+}
+
+// Return a copy of the Machine that carries the operator's annotations of the owner MachineSet, like
+// the enabled and token-name annotations. The annotations found on the Machine itself take precedence.
+// Include and exclude paths are converted to the Machine paths.
+func InheritControlAnnotations(machine *unstructured.Unstructured, machineSet *unstructured.Unstructured) *unstructured.Unstructured {
+	inherited := machine.DeepCopy()
+	if machineSet == nil {
+		return inherited
+	}
+
+	annotations := inherited.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	for key, value := range machineSet.GetAnnotations() {
+		if !strings.HasPrefix(key, AnnotationBase+"/") {
+			continue
+		}
+		if _, found := annotations[key]; found {
+			continue
+		}
+		if pathAnnotations[key] {
+			value = machinePaths(value)
+			// None of the included paths applies to the Machine. An empty list would include everything,
+			// include the template path instead, it matches no Machine field.
+			if key == AnnotationIncludePaths && value == "" {
+				value = machineSetTemplatePath
+			}
+		}
+		annotations[key] = value
+	}
+	inherited.SetAnnotations(annotations)
+	return inherited
+}
+
+// Keys of the labels that the selector of the MachineSet matches on. The tokens in these Machine labels
+// are not replaced, the Machine would no longer match the selector of its owner.
+func SelectorLabelKeys(machineSet *unstructured.Unstructured) []string {
+	if machineSet == nil {
+		return nil
+	}
+	keys := []string{}
+	matchLabels, _, _ := unstructured.NestedStringMap(machineSet.UnstructuredContent(), FieldSpec, FieldSelector, FieldMatchLabels)
+	for key := range matchLabels {
+		keys = append(keys, key)
+	}
+	matchExpressions, _, _ := unstructured.NestedSlice(machineSet.UnstructuredContent(), FieldSpec, FieldSelector, FieldMatchExpressions)
+	for _, expression := range matchExpressions {
+		if expressionMap, ok := expression.(map[string]interface{}); ok {
+			if key, ok := expressionMap[FieldKey].(string); ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// Convert the MachineSet template paths to the Machine paths. Other paths refer to the MachineSet
+// itself and are dropped.
+func machinePaths(pathsString string) string {
+	paths := []string{}
+	for _, path := range strings.Split(pathsString, ",") {
+		path = strings.TrimSpace(path)
+		if strings.HasPrefix(path, machineSetTemplatePath+"/") {
+			paths = append(paths, strings.TrimPrefix(path, machineSetTemplatePath))
+		}
+	}
+	return strings.Join(paths, ",")
+}
@@ -0,0 +1,124 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	machineapi "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestMachine(ownerName string, ownerUID types.UID) *unstructured.Unstructured {
+	machine := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machine.SetGroupVersionKind(machineapi.SchemeGroupVersion.WithKind(KindMachine))
+	machine.SetName(ownerName + "-abcde")
+	machine.SetNamespace(NamespaceOpenShiftMachineApi)
+	controller := true
+	machine.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: machineapi.SchemeGroupVersion.String(),
+		Kind:       KindMachineSet,
+		Name:       ownerName,
+		UID:        ownerUID,
+		Controller: &controller,
+	}})
+	return machine
+}
+
+func TestGetOwnerMachineSet(t *testing.T) {
+	assert := assert.New(t)
+
+	scheme := runtime.NewScheme()
+	machineapi.AddToScheme(scheme)
+
+	owner := &machineapi.MachineSet{ObjectMeta: metav1.ObjectMeta{
+		Name:      "mycluster-worker-us-east-2a",
+		Namespace: NamespaceOpenShiftMachineApi,
+		UID:       "1234",
+	}}
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(owner).Build()
+
+	machineSet, err := GetOwnerMachineSet(context.TODO(), logger, reader, newTestMachine(owner.Name, owner.UID))
+	assert.Nil(err)
+	assert.NotNil(machineSet)
+	assert.Equal(owner.Name, machineSet.GetName())
+
+	// The MachineSet was re-created
+	machineSet, err = GetOwnerMachineSet(context.TODO(), logger, reader, newTestMachine(owner.Name, "5678"))
+	assert.Nil(err)
+	assert.Nil(machineSet)
+
+	// The MachineSet doesn't exist
+	machineSet, err = GetOwnerMachineSet(context.TODO(), logger, reader, newTestMachine("deleted", "1234"))
+	assert.Nil(err)
+	assert.Nil(machineSet)
+
+	// The Machine isn't owned by a MachineSet
+	machine := newTestMachine(owner.Name, owner.UID)
+	machine.SetOwnerReferences(nil)
+	assert.Nil(OwnerMachineSetReference(machine))
+	machineSet, err = GetOwnerMachineSet(context.TODO(), logger, reader, machine)
+	assert.Nil(err)
+	assert.Nil(machineSet)
+}
+
+func TestInheritControlAnnotations(t *testing.T) {
+	assert := assert.New(t)
+
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machineSet.SetAnnotations(map[string]string{
+		AnnotationEnabled:      "true",
+		AnnotationTokenName:    "MYTOKEN",
+		AnnotationTokens:       TokenRegion,
+		AnnotationExcludePaths: "/spec/template/spec/providerSpec/value/userDataSecret,/metadata/labels",
+		"other":                "value",
+	})
+
+	machine := newTestMachine("mycluster-worker-us-east-2a", "1234")
+	machine.SetAnnotations(map[string]string{AnnotationTokens: TokenPlatform})
+
+	inherited := InheritControlAnnotations(machine, machineSet)
+	assert.Equal(map[string]string{
+		AnnotationEnabled:      "true",
+		AnnotationTokenName:    "MYTOKEN",
+		AnnotationTokens:       TokenPlatform,
+		AnnotationExcludePaths: "/spec/providerSpec/value/userDataSecret",
+	}, inherited.GetAnnotations())
+
+	// The Machine itself is left unchanged
+	assert.Equal(map[string]string{AnnotationTokens: TokenPlatform}, machine.GetAnnotations())
+
+	// Include paths that don't apply to the Machine include nothing
+	machineSet.SetAnnotations(map[string]string{AnnotationIncludePaths: "/metadata/labels"})
+	inherited = InheritControlAnnotations(machine, machineSet)
+	assert.Equal("/spec/template", inherited.GetAnnotations()[AnnotationIncludePaths])
+	assert.False(NewPathFilter(logger, inherited).Allows("/spec/providerSpec/value/ami"))
+
+	// Without an owner, the Machine is copied as is
+	assert.Equal(machine, InheritControlAnnotations(machine, nil))
+}
+
+func TestSelectorLabelKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"selector": map[string]interface{}{
+				"matchLabels": map[string]interface{}{
+					"machine.openshift.io/cluster-api-cluster": "INFRANAME",
+				},
+				"matchExpressions": []interface{}{
+					map[string]interface{}{"key": "example.com/zone", "operator": "Exists"},
+				},
+			},
+		},
+	}}
+	assert.ElementsMatch([]string{"machine.openshift.io/cluster-api-cluster", "example.com/zone"}, SelectorLabelKeys(machineSet))
+
+	// The Machine isn't owned by a MachineSet
+	assert.Empty(SelectorLabelKeys(nil))
+}
@@ -0,0 +1,119 @@
+package common
+
+import (
+	"strings"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Restricts token substitution to parts of the object. The paths are JSON pointers, a path covers
+// the respective field and everything beneath it.
+type PathFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Read the path filter from the object annotations. Both annotations hold a comma-separated list
+// of JSON pointers, for example "/spec/template/spec/providerSpec,/metadata/labels".
+func NewPathFilter(logger logr.Logger, obj *unstructured.Unstructured) *PathFilter {
+	annotations := obj.GetAnnotations()
+	return &PathFilter{
+		Include: parsePaths(logger, annotations[AnnotationIncludePaths], AnnotationIncludePaths),
+		Exclude: parsePaths(logger, annotations[AnnotationExcludePaths], AnnotationExcludePaths),
+	}
+}
+
+func parsePaths(logger logr.Logger, pathsString, annotation string) []string {
+	paths := []string{}
+	for _, path := range strings.Split(pathsString, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if !strings.HasPrefix(path, "/") {
+			logger.Info("Ignoring invalid path \"" + path + "\" listed in annotation \"" + annotation + "\". Path must start with \"/\".")
+			continue
+		}
+		paths = append(paths, strings.TrimSuffix(path, "/"))
+	}
+	return paths
+}
+
+// JSON pointer prefixes of the operator's own annotations. These are never subject to substitution.
+var controlAnnotationPaths = []string{
+	JoinJSONPointer("/"+FieldMetadata+"/"+FieldAnnotations, AnnotationBase+"/"),
+	JoinJSONPointer("/"+FieldSpec+"/"+FieldTemplate+"/"+FieldMetadata+"/"+FieldAnnotations, AnnotationBase+"/"),
+}
+
+func isControlAnnotationPath(path string) bool {
+	for _, prefix := range controlAnnotationPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// JSON pointers of the annotations of the MachineSet, its template and the Machine.
+var annotationsPaths = []string{
+	"/" + FieldMetadata + "/" + FieldAnnotations,
+	"/" + FieldSpec + "/" + FieldTemplate + "/" + FieldMetadata + "/" + FieldAnnotations,
+}
+
+// Check if the path points at an annotation reserved for the Kubernetes components, like
+// kubectl.kubernetes.io/last-applied-configuration. These are written by tools, not by the user.
+func isSystemAnnotationPath(path string) bool {
+	for _, annotationsPath := range annotationsPaths {
+		if !strings.HasPrefix(path, annotationsPath+"/") {
+			continue
+		}
+		key := strings.SplitN(strings.TrimPrefix(path, annotationsPath+"/"), "/", 2)[0]
+		return isSystemAnnotation(jsonPointerUnescaper.Replace(key))
+	}
+	return false
+}
+
+func isSystemAnnotation(key string) bool {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) < 2 {
+		return false
+	}
+	prefix := parts[0]
+	for _, domain := range []string{"kubernetes.io", "k8s.io"} {
+		if prefix == domain || strings.HasSuffix(prefix, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func coversPath(prefix, path string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// Check if the field at the given path is eligible for substitution. If no include paths are given,
+// all fields are eligible. Exclude paths take precedence over include paths. The operator's own
+// annotations and the annotations reserved for the Kubernetes components are never eligible.
+func (f *PathFilter) Allows(path string) bool {
+	if isControlAnnotationPath(path) || isSystemAnnotationPath(path) {
+		return false
+	}
+	if f == nil {
+		return true
+	}
+	for _, exclude := range f.Exclude {
+		if coversPath(exclude, path) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, include := range f.Include {
+		if coversPath(include, path) {
+			return true
+		}
+	}
+	return false
+}
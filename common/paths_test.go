@@ -0,0 +1,48 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestNewPathFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	input := &unstructured.Unstructured{}
+	input.SetAnnotations(map[string]string{
+		AnnotationIncludePaths: "/spec/template/, /metadata/labels,spec",
+		AnnotationExcludePaths: "/spec/template/spec/providerSpec/value/tags",
+	})
+	assert.Equal(&PathFilter{
+		Include: []string{"/spec/template", "/metadata/labels"},
+		Exclude: []string{"/spec/template/spec/providerSpec/value/tags"}}, NewPathFilter(logger, input))
+}
+
+func TestPathFilterAllows(t *testing.T) {
+	assert := assert.New(t)
+
+	var filter *PathFilter
+
+	assert.Equal(true, filter.Allows("/spec/replicas"))
+	assert.Equal(true, filter.Allows("/metadata/annotations/autoscaling.openshift.io~1machineautoscaler"))
+	assert.Equal(false, filter.Allows("/metadata/annotations/gitops-friendly-machinesets.redhat-cop.io~1token-name"))
+	assert.Equal(false, filter.Allows("/spec/template/metadata/annotations/gitops-friendly-machinesets.redhat-cop.io~1token-name"))
+	assert.Equal(false, filter.Allows("/metadata/annotations/kubectl.kubernetes.io~1last-applied-configuration"))
+	assert.Equal(false, filter.Allows("/spec/template/metadata/annotations/kubernetes.io~1description"))
+	assert.Equal(true, filter.Allows("/metadata/annotations/example.com~1kubernetes.io"))
+
+	filter = &PathFilter{}
+	assert.Equal(true, filter.Allows("/spec/replicas"))
+
+	filter = &PathFilter{
+		Include: []string{"/spec/template"},
+		Exclude: []string{"/spec/template/spec/providerSpec/value/tags"},
+	}
+	assert.Equal(true, filter.Allows("/spec/template"))
+	assert.Equal(true, filter.Allows("/spec/template/metadata/labels/role"))
+	assert.Equal(false, filter.Allows("/spec/templateX"))
+	assert.Equal(false, filter.Allows("/metadata/labels/role"))
+	assert.Equal(false, filter.Allows("/spec/template/spec/providerSpec/value/tags/0/name"))
+}
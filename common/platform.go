@@ -0,0 +1,36 @@
+package common
+
+import (
+	"context"
+	"strings"
+
+	configapi "github.com/openshift/api/config/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Resolves the tokens naming the resources that the OpenShift installer created on one platform. All tokens
+// of the platform are returned, they have an empty value unless the cluster runs on the platform.
+type platformTokenResolver struct {
+	platform configapi.PlatformType
+	tokens   []string
+	// Values of the tokens on a cluster running on the platform
+	resolve func(obj *unstructured.Unstructured, cluster *ClusterInfo) map[string]string
+}
+
+func (r platformTokenResolver) Name() string {
+	return strings.ToLower(string(r.platform))
+}
+
+func (r platformTokenResolver) ResolveTokens(ctx context.Context, obj *unstructured.Unstructured, cluster *ClusterInfo) (map[string]string, error) {
+	tokens := map[string]string{}
+	for _, name := range r.tokens {
+		tokens[name] = ""
+	}
+	if configapi.PlatformType(cluster.PlatformType()) != r.platform {
+		return tokens, nil
+	}
+	for name, value := range r.resolve(obj, cluster) {
+		tokens[name] = value
+	}
+	return tokens, nil
+}
@@ -0,0 +1,38 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	configapi "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestPlatformTokenResolver(t *testing.T) {
+	assert := assert.New(t)
+
+	cluster := &ClusterInfo{
+		Infrastructure: configapi.InfrastructureStatus{
+			InfrastructureName: "mycluster-jfnx7",
+			PlatformStatus:     &configapi.PlatformStatus{Type: configapi.GCPPlatformType},
+		},
+	}
+	input := &unstructured.Unstructured{Object: map[string]interface{}{}}
+
+	// All tokens are listed, the tokens that don't apply to this cluster have an empty value
+	tokens, err := awsTokenResolver.ResolveTokens(context.TODO(), input, cluster)
+	assert.Nil(err)
+	assert.Equal("aws", awsTokenResolver.Name())
+	assert.Len(tokens, len(awsTokenResolver.tokens))
+	for _, value := range tokens {
+		assert.Equal("", value)
+	}
+
+	// The tokens missing on this cluster are listed as well
+	tokens, err = gcpTokenResolver.ResolveTokens(context.TODO(), input, cluster)
+	assert.Nil(err)
+	assert.Len(tokens, len(gcpTokenResolver.tokens))
+	assert.Equal("mycluster-jfnx7-network", tokens[TokenGCPNetwork])
+	assert.Equal("", tokens[TokenGCPProjectID])
+}
@@ -0,0 +1,27 @@
+package common
+
+import (
+	"github.com/go-logr/logr"
+	"github.com/noseka1/gitops-friendly-machinesets-operator/resolvers"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Registry with the platform-specific resolvers shipped with the operator.
+func NewDefaultTokenResolverRegistry() *resolvers.TokenResolverRegistry {
+	return resolvers.NewTokenResolverRegistry(PlatformTokenResolvers()...)
+}
+
+// Resolvers for the installer resource names on AWS, Azure, GCP and OpenStack.
+func PlatformTokenResolvers() []resolvers.TokenResolver {
+	return []resolvers.TokenResolver{
+		awsTokenResolver,
+		azureTokenResolver,
+		gcpTokenResolver,
+		openStackTokenResolver,
+	}
+}
+
+// Zone the Machines are placed in. The zone may be written using the built-in tokens, for example REGIONa.
+func ResolveZone(obj *unstructured.Unstructured, cluster *ClusterInfo) string {
+	return NewSubstitution(logr.Discard(), obj, BuiltinTokens(cluster)).replace(ProviderSpecZone(obj))
+}
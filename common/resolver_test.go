@@ -0,0 +1,52 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	configapi "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type testTokenResolver struct {
+	tokens map[string]string
+	err    error
+}
+
+func (r testTokenResolver) Name() string {
+	return "test"
+}
+
+func (r testTokenResolver) ResolveTokens(ctx context.Context, obj *unstructured.Unstructured, cluster *ClusterInfo) (map[string]string, error) {
+	return r.tokens, r.err
+}
+
+func TestResolveTokensWithResolvers(t *testing.T) {
+	assert := assert.New(t)
+
+	cluster := &ClusterInfo{
+		Infrastructure: configapi.InfrastructureStatus{
+			InfrastructureName: "mycluster-jfnx7",
+			PlatformStatus: &configapi.PlatformStatus{
+				Type: configapi.AWSPlatformType,
+				AWS:  &configapi.AWSPlatformStatus{Region: "us-east-2"},
+			},
+		},
+	}
+
+	registry := NewDefaultTokenResolverRegistry()
+	registry.Register(testTokenResolver{tokens: map[string]string{"IPAM_ADDRESS": "10.0.0.1", "REGION": "clash"}})
+
+	// Resolver tokens must be selected, they cannot override built-in tokens
+	input := &unstructured.Unstructured{}
+	input.SetAnnotations(map[string]string{AnnotationTokens: "REGION,IPAM_ADDRESS,AWS_LB_SECURITY_GROUP"})
+	tokens, err := ResolveTokens(context.TODO(), logger, fake.NewClientBuilder().Build(), input, DefaultTokenName, cluster, registry)
+	assert.Nil(err)
+	assert.Equal(map[string]string{
+		"INFRANAME":             "mycluster-jfnx7",
+		"REGION":                "us-east-2",
+		"IPAM_ADDRESS":          "10.0.0.1",
+		"AWS_LB_SECURITY_GROUP": "mycluster-jfnx7-lb"}, tokens)
+}
@@ -0,0 +1,96 @@
+package common
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Reference to a ConfigMap or a Secret in the namespace of the object. Each key found in the
+// ConfigMap or Secret data defines a token that is going to be replaced with the respective value.
+type TokenSource struct {
+	Kind string
+	Name string
+}
+
+func (s TokenSource) String() string {
+	return s.Kind + "/" + s.Name
+}
+
+// Parse the token sources annotation. The annotation holds a comma-separated list of references
+// in the form configmap/<name> or secret/<name>. Invalid references are ignored.
+func ParseTokenSources(logger logr.Logger, obj *unstructured.Unstructured) []TokenSource {
+	annotations := obj.GetAnnotations()
+	sourcesString, sourcesFound := annotations[AnnotationTokensFrom]
+	if !sourcesFound {
+		return nil
+	}
+
+	sources := []TokenSource{}
+	for _, ref := range strings.Split(sourcesString, ",") {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			continue
+		}
+		parts := strings.SplitN(ref, "/", 2)
+		if len(parts) != 2 || parts[1] == "" ||
+			(parts[0] != TokenSourceKindConfigMap && parts[0] != TokenSourceKindSecret) {
+			logger.Info("Ignoring invalid token source \"" + ref + "\" listed in annotation \"" + AnnotationTokensFrom + "\".")
+			continue
+		}
+		sources = append(sources, TokenSource{Kind: parts[0], Name: parts[1]})
+	}
+	return sources
+}
+
+// Check if the object references the given ConfigMap or Secret as a token source.
+func ReferencesTokenSource(logger logr.Logger, obj *unstructured.Unstructured, source TokenSource) bool {
+	for _, s := range ParseTokenSources(logger, obj) {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// Read the tokens from all ConfigMaps and Secrets referenced by the object. A token source that
+// doesn't exist yet is skipped, its tokens will be replaced once it has been created.
+func LoadTokenSources(ctx context.Context, logger logr.Logger, reader client.Reader, obj *unstructured.Unstructured) (map[string]string, error) {
+	tokens := map[string]string{}
+
+	for _, source := range ParseTokenSources(logger, obj) {
+		key := client.ObjectKey{Namespace: obj.GetNamespace(), Name: source.Name}
+
+		var err error
+		switch source.Kind {
+		case TokenSourceKindConfigMap:
+			configMap := &corev1.ConfigMap{}
+			if err = reader.Get(ctx, key, configMap); err == nil {
+				for name, value := range configMap.Data {
+					tokens[name] = value
+				}
+			}
+		case TokenSourceKindSecret:
+			secret := &corev1.Secret{}
+			if err = reader.Get(ctx, key, secret); err == nil {
+				for name, value := range secret.Data {
+					tokens[name] = string(value)
+				}
+			}
+		}
+
+		if apierrors.IsNotFound(err) {
+			logger.Info("Token source \"" + source.String() + "\" not found. Skipping it.")
+		} else if err != nil {
+			logger.Error(err, "Failed to retrieve token source \""+source.String()+"\".")
+			return nil, err
+		}
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	configapi "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestParseTokenSources(t *testing.T) {
+	assert := assert.New(t)
+
+	var input *unstructured.Unstructured
+
+	input = &unstructured.Unstructured{}
+	assert.Equal(0, len(ParseTokenSources(logger, input)))
+
+	input = &unstructured.Unstructured{}
+	input.SetAnnotations(map[string]string{
+		AnnotationTokensFrom: "configmap/mytokens, secret/mysecret,deployment/foo,configmap/,secret",
+	})
+	assert.Equal([]TokenSource{
+		{Kind: TokenSourceKindConfigMap, Name: "mytokens"},
+		{Kind: TokenSourceKindSecret, Name: "mysecret"}}, ParseTokenSources(logger, input))
+
+	assert.Equal(true, ReferencesTokenSource(logger, input, TokenSource{Kind: TokenSourceKindSecret, Name: "mysecret"}))
+	assert.Equal(false, ReferencesTokenSource(logger, input, TokenSource{Kind: TokenSourceKindConfigMap, Name: "mysecret"}))
+}
+
+func TestLoadTokenSources(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := fake.NewClientBuilder().WithObjects(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: NamespaceOpenShiftMachineApi, Name: "mytokens"},
+			Data:       map[string]string{"SUBNET": "subnet-0a1b2c", "INFRANAME": "shadowed"},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: NamespaceOpenShiftMachineApi, Name: "mysecret"},
+			Data:       map[string][]byte{"FOLDER": []byte("/Datacenter/vm/mycluster")},
+		}).Build()
+
+	input := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	input.SetNamespace(NamespaceOpenShiftMachineApi)
+	input.SetAnnotations(map[string]string{
+		AnnotationTokensFrom: "configmap/mytokens,secret/mysecret,configmap/missing",
+	})
+
+	tokens, err := LoadTokenSources(context.TODO(), logger, reader, input)
+	assert.Equal(nil, err)
+	assert.Equal(map[string]string{
+		"SUBNET":    "subnet-0a1b2c",
+		"INFRANAME": "shadowed",
+		"FOLDER":    "/Datacenter/vm/mycluster"}, tokens)
+
+	cluster := &ClusterInfo{Infrastructure: configapi.InfrastructureStatus{InfrastructureName: "mycluster-jfnx7"}}
+	input.SetAnnotations(map[string]string{
+		AnnotationTokensFrom: "configmap/mytokens",
+	})
+	tokens, err = ResolveTokens(context.TODO(), logger, reader, input, DefaultTokenName, cluster, nil)
+	assert.Equal(nil, err)
+	assert.Equal(map[string]string{
+		"SUBNET":    "subnet-0a1b2c",
+		"INFRANAME": "mycluster-jfnx7"}, tokens)
+}
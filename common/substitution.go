@@ -0,0 +1,182 @@
+package common
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const machineSetTemplatePath = "/" + FieldSpec + "/" + FieldTemplate
+
+// Substitution replaces the tokens in the string leaves of the object sections according to the
+// settings found in the object annotations.
+type Substitution struct {
+	Tokens map[string]string
+	Syntax string
+	Filter *PathFilter
+	// Paths where an escaped token has been written literally in the past. The value found at such
+	// a path is only processed again if it contains escaped tokens, i.e. if it has been overwritten
+	// by the user. Otherwise the literal tokens would get replaced when the object is processed again.
+	LiteralPaths []string
+}
+
+func NewSubstitution(logger logr.Logger, obj *unstructured.Unstructured, tokens map[string]string) *Substitution {
+	return &Substitution{
+		Tokens:       tokens,
+		Syntax:       GetTokenSyntax(logger, obj),
+		Filter:       NewPathFilter(logger, obj),
+		LiteralPaths: GetLiteralPaths(obj),
+	}
+}
+
+// Literal paths are recorded in the MachineSet annotations. They are kept out of the MachineSet template,
+// so that the template doesn't drift from its source and its generation doesn't change. The Machines
+// inherit the paths from their owner MachineSet, converted to the Machine paths.
+func GetLiteralPaths(obj *unstructured.Unstructured) []string {
+	paths := []string{}
+	for _, path := range strings.Split(obj.GetAnnotations()[AnnotationLiteralPaths], ",") {
+		if path == "" {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// Record the literal paths in the MachineSet annotations of the object sections.
+func setLiteralPaths(section map[string]interface{}, paths []string) {
+	annotations, _, _ := unstructured.NestedStringMap(section, FieldMetadata, FieldAnnotations)
+	if annotations == nil {
+		if len(paths) == 0 {
+			return
+		}
+		annotations = map[string]string{}
+	}
+	if len(paths) == 0 {
+		delete(annotations, AnnotationLiteralPaths)
+	} else {
+		sort.Strings(paths)
+		annotations[AnnotationLiteralPaths] = strings.Join(paths, ",")
+	}
+	unstructured.SetNestedStringMap(section, annotations, FieldMetadata, FieldAnnotations)
+}
+
+func (s *Substitution) isLiteralPath(path string) bool {
+	for _, literalPath := range s.LiteralPaths {
+		if path == literalPath {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Substitution) containsEscapedTokens(value string) bool {
+	pattern, delimited := delimitedTokenPatterns[s.Syntax]
+	if !delimited {
+		return false
+	}
+	for _, match := range pattern.FindAllString(value, -1) {
+		if isEscaped(match) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if the value at the given path is subject to substitution.
+func (s *Substitution) processes(path, value string) bool {
+	if !s.Filter.Allows(path) {
+		return false
+	}
+	return !s.isLiteralPath(path) || s.containsEscapedTokens(value)
+}
+
+// Resolve a single match of the delimited token pattern. Returns false if the token is unknown
+// or if the transformations cannot be applied.
+func (s *Substitution) resolve(pattern *regexp.Regexp, match string) (string, bool) {
+	submatch := pattern.FindStringSubmatch(match)
+	tokenValue, found := s.Tokens[submatch[1]]
+	if !found {
+		return match, false
+	}
+	return applyTransforms(tokenValue, submatch[2])
+}
+
+func (s *Substitution) replace(value string) string {
+	pattern, delimited := delimitedTokenPatterns[s.Syntax]
+	if !delimited {
+		return newTokenReplacer(s.Tokens).Replace(value)
+	}
+	return pattern.ReplaceAllStringFunc(value, func(match string) string {
+		if isEscaped(match) {
+			return match[1:]
+		}
+		if resolved, ok := s.resolve(pattern, match); ok {
+			return resolved
+		}
+		return match
+	})
+}
+
+func (s *Substitution) contains(value string) bool {
+	pattern, delimited := delimitedTokenPatterns[s.Syntax]
+	if !delimited {
+		for name := range s.Tokens {
+			if strings.Contains(value, name) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, match := range pattern.FindAllString(value, -1) {
+		if isEscaped(match) {
+			continue
+		}
+		if _, ok := s.resolve(pattern, match); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if any of the tokens can be found in the string leaves of the object sections that are
+// eligible for substitution.
+func (s *Substitution) ContainsTokens(section map[string]interface{}) bool {
+	return len(s.FindTokens(section)) > 0
+}
+
+// Find the string leaves that contain tokens eligible for substitution. Returns the values keyed by
+// their paths.
+func (s *Substitution) FindTokens(section map[string]interface{}) map[string]string {
+	found := map[string]string{}
+	TransformStringLeaves(section, "", func(path, value string) (string, error) {
+		if s.processes(path, value) && s.contains(value) {
+			found[path] = value
+		}
+		return value, nil
+	})
+	return found
+}
+
+// Replace the tokens in the string leaves of the object sections that are eligible for substitution.
+// Map keys, numbers and booleans are left untouched. Returns the paths where escaped tokens have been
+// written literally, including the literal paths that were recorded before and were left untouched.
+func (s *Substitution) ReplaceTokens(section map[string]interface{}) []string {
+	literalPaths := []string{}
+	TransformStringLeaves(section, "", func(path, value string) (string, error) {
+		if !s.processes(path, value) {
+			if s.isLiteralPath(path) {
+				literalPaths = append(literalPaths, path)
+			}
+			return value, nil
+		}
+		if s.containsEscapedTokens(value) {
+			literalPaths = append(literalPaths, path)
+		}
+		return s.replace(value), nil
+	})
+	return literalPaths
+}
@@ -0,0 +1,145 @@
+package common
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	jsonpatch "gomodules.xyz/jsonpatch/v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestContainsTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	substitution := &Substitution{
+		Tokens: map[string]string{"INFRANAME": "mycluster", "REGION": "us-east-2"},
+		Syntax: TokenSyntaxBare,
+	}
+
+	var section map[string]interface{}
+
+	section = map[string]interface{}{"spec": map[string]interface{}{}}
+	assert.Equal(false, substitution.ContainsTokens(section))
+
+	section = map[string]interface{}{"spec": map[string]interface{}{"region": "REGION"}}
+	assert.Equal(true, substitution.ContainsTokens(section))
+	substitution.Filter = &PathFilter{Exclude: []string{"/spec/region"}}
+	assert.Equal(false, substitution.ContainsTokens(section))
+	substitution.Filter = nil
+
+	section = map[string]interface{}{"spec": map[string]interface{}{"REGION": "us-east-2"}}
+	assert.Equal(false, substitution.ContainsTokens(section))
+
+	substitution.Syntax = TokenSyntaxDollar
+
+	section = map[string]interface{}{"spec": map[string]interface{}{"region": "REGION"}}
+	assert.Equal(false, substitution.ContainsTokens(section))
+
+	section = map[string]interface{}{"spec": map[string]interface{}{"region": "${REGION}"}}
+	assert.Equal(true, substitution.ContainsTokens(section))
+
+	section = map[string]interface{}{"spec": map[string]interface{}{"region": `\${REGION} ${UNKNOWN}`}}
+	assert.Equal(false, substitution.ContainsTokens(section))
+
+	substitution.LiteralPaths = []string{"/spec/region"}
+	section = map[string]interface{}{"spec": map[string]interface{}{"region": "${REGION}"}}
+	assert.Equal(false, substitution.ContainsTokens(section))
+}
+
+func TestReplaceTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	tokens := map[string]string{"INFRANAME": "mycluster", "REGION": "us-east-2"}
+
+	var substitution *Substitution
+	var section map[string]interface{}
+
+	substitution = &Substitution{Tokens: tokens, Syntax: TokenSyntaxBraces}
+	section = map[string]interface{}{
+		"a": "INFRANAME-{{INFRANAME}}-{{ REGION }}",
+		"b": `\{{INFRANAME}}-{{UNKNOWN}}`,
+	}
+	assert.Equal([]string{"/b"}, substitution.ReplaceTokens(section))
+	assert.Equal(map[string]interface{}{
+		"a": "INFRANAME-mycluster-us-east-2",
+		"b": "{{INFRANAME}}-{{UNKNOWN}}",
+	}, section)
+
+	// Processing the same object again leaves the literal tokens untouched
+	substitution = &Substitution{Tokens: tokens, Syntax: TokenSyntaxBraces, LiteralPaths: []string{"/b"}}
+	assert.Equal([]string{"/b"}, substitution.ReplaceTokens(section))
+	assert.Equal("{{INFRANAME}}-{{UNKNOWN}}", section["b"])
+
+	// Unless the value has been overwritten with escaped tokens again
+	section["b"] = `\${INFRANAME}-${REGION}`
+	substitution = &Substitution{Tokens: tokens, Syntax: TokenSyntaxDollar, LiteralPaths: []string{"/b"}}
+	assert.Equal([]string{"/b"}, substitution.ReplaceTokens(section))
+	assert.Equal("${INFRANAME}-us-east-2", section["b"])
+}
+
+func TestGetLiteralPaths(t *testing.T) {
+	assert := assert.New(t)
+
+	var obj *unstructured.Unstructured
+
+	obj = &unstructured.Unstructured{Object: map[string]interface{}{}}
+	assert.Equal([]string{}, GetLiteralPaths(obj))
+
+	obj = &unstructured.Unstructured{Object: map[string]interface{}{}}
+	obj.SetKind(KindMachineSet)
+	obj.SetAnnotations(map[string]string{
+		AnnotationLiteralPaths: "/metadata/labels/a,/spec/template/spec/providerSpec/value/b",
+	})
+	assert.Equal([]string{"/metadata/labels/a", "/spec/template/spec/providerSpec/value/b"}, GetLiteralPaths(obj))
+
+	// The Machine inherits the paths converted to the Machine paths
+	machine := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machine.SetKind(KindMachine)
+	obj.SetAnnotations(map[string]string{
+		AnnotationLiteralPaths: "/metadata/labels/a,/spec/template/spec/providerSpec/value/b,/spec/template/metadata/labels/c",
+	})
+	assert.Equal([]string{"/spec/providerSpec/value/b", "/metadata/labels/c"}, GetLiteralPaths(InheritControlAnnotations(machine, obj)))
+}
+
+func TestCreatePatchRecordsLiteralPaths(t *testing.T) {
+	assert := assert.New(t)
+
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machineSet.SetKind(KindMachineSet)
+	machineSet.SetAnnotations(map[string]string{AnnotationTokenSyntax: TokenSyntaxDollar})
+	unstructured.SetNestedField(machineSet.UnstructuredContent(), `\${INFRANAME}`, "spec", "template", "spec", "providerSpec", "value", "description")
+
+	patchBytes, err := CreatePatch(logger, machineSet, map[string]string{"INFRANAME": "mycluster"}, nil)
+	assert.Equal(nil, err)
+
+	patch := []jsonpatch.Operation{}
+	err = json.Unmarshal(patchBytes, &patch)
+	assert.Equal(nil, err)
+	assert.ElementsMatch([]jsonpatch.Operation{
+		{Operation: "replace",
+			Path:  "/spec/template/spec/providerSpec/value/description",
+			Value: "${INFRANAME}"},
+		{Operation: "add",
+			Path:  "/metadata/annotations/" + strings.ReplaceAll(AnnotationLiteralPaths, "/", "~1"),
+			Value: "/spec/template/spec/providerSpec/value/description"}}, patch)
+}
+
+func TestSubstitutionTransforms(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &Substitution{
+		Tokens: map[string]string{"INFRANAME": "Cluster-ABC"},
+		Syntax: TokenSyntaxDollar,
+	}
+
+	assert.Equal("cluster-abc-worker", s.replace("${INFRANAME|lower}-worker"))
+	assert.Equal("clus", s.replace("${ INFRANAME | lower | truncate:4 }"))
+	assert.Equal("${INFRANAME|unknown}", s.replace("${INFRANAME|unknown}"))
+	assert.Equal("${INFRANAME|lower}", s.replace("\\${INFRANAME|lower}"))
+
+	assert.True(s.contains("${INFRANAME|lower}"))
+	assert.False(s.contains("${INFRANAME|unknown}"))
+	assert.False(s.contains("\\${INFRANAME|lower}"))
+}
@@ -0,0 +1,52 @@
+package common
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Token name followed by an optional chain of transformations, for example INFRANAME|lower|truncate:20
+const tokenExpression = `\s*([A-Za-z0-9_][A-Za-z0-9_.-]*)((?:\s*\|\s*[a-z]+(?::[A-Za-z0-9]+)?)*)\s*`
+
+// Patterns matching a token written in one of the delimited syntaxes. A token preceded by a backslash
+// is escaped and will be written literally without the backslash.
+var delimitedTokenPatterns = map[string]*regexp.Regexp{
+	TokenSyntaxDollar: regexp.MustCompile(`\\?\$\{` + tokenExpression + `\}`),
+	TokenSyntaxBraces: regexp.MustCompile(`\\?\{\{` + tokenExpression + `\}\}`),
+}
+
+// Determine the syntax in which the tokens are written in the object. Defaults to bare tokens.
+func GetTokenSyntax(logger logr.Logger, obj *unstructured.Unstructured) string {
+	annotations := obj.GetAnnotations()
+	syntax, syntaxFound := annotations[AnnotationTokenSyntax]
+	if !syntaxFound || syntax == TokenSyntaxBare {
+		return TokenSyntaxBare
+	}
+	if _, known := delimitedTokenPatterns[syntax]; !known {
+		logger.Info("Unknown token syntax \"" + syntax + "\" in annotation \"" + AnnotationTokenSyntax + "\". Using \"" + TokenSyntaxBare + "\" syntax.")
+		return TokenSyntaxBare
+	}
+	return syntax
+}
+
+func isEscaped(match string) bool {
+	return strings.HasPrefix(match, `\`)
+}
+
+// Replacer that replaces all the bare tokens in a single pass. Longer token names take precedence so that
+// a token which is a prefix of another token doesn't clobber it.
+func newTokenReplacer(tokens map[string]string) *strings.Replacer {
+	names := TokenNames(tokens)
+	sort.SliceStable(names, func(i, j int) bool {
+		return len(names[i]) > len(names[j])
+	})
+	oldNew := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		oldNew = append(oldNew, name, tokens[name])
+	}
+	return strings.NewReplacer(oldNew...)
+}
@@ -0,0 +1,25 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestGetTokenSyntax(t *testing.T) {
+	assert := assert.New(t)
+
+	var input *unstructured.Unstructured
+
+	input = &unstructured.Unstructured{}
+	assert.Equal(TokenSyntaxBare, GetTokenSyntax(logger, input))
+
+	input = &unstructured.Unstructured{}
+	input.SetAnnotations(map[string]string{AnnotationTokenSyntax: "dollar"})
+	assert.Equal(TokenSyntaxDollar, GetTokenSyntax(logger, input))
+
+	input = &unstructured.Unstructured{}
+	input.SetAnnotations(map[string]string{AnnotationTokenSyntax: "percent"})
+	assert.Equal(TokenSyntaxBare, GetTokenSyntax(logger, input))
+}
@@ -0,0 +1,96 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	configapi "github.com/openshift/api/config/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Data model available to the templates. The platform specific information can be accessed using
+// for example {{ .Infrastructure.PlatformStatus.AWS.Region }}.
+type TemplateData struct {
+	Infrastructure configapi.InfrastructureStatus
+	Platform       string
+	Region         string
+	ClusterName    string
+	BaseDomain     string
+	ClusterID      string
+	Tokens         map[string]string
+}
+
+var templateFuncs = template.FuncMap{
+	"default": func(defaultValue, value interface{}) interface{} {
+		if value == nil || value == "" {
+			return defaultValue
+		}
+		return value
+	},
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"truncate":   func(length int, s string) string { return truncateWithHash(s, length) },
+	"sanitize":   sanitizeLabelValue,
+}
+
+func IsTemplateRenderingEnabled(obj *unstructured.Unstructured) bool {
+	annotations := obj.GetAnnotations()
+	templateString, templateFound := annotations[AnnotationTemplate]
+	return templateFound && templateString == "true"
+}
+
+func NewTemplateData(cluster *ClusterInfo, tokens map[string]string) *TemplateData {
+	if cluster == nil {
+		cluster = &ClusterInfo{}
+	}
+	return &TemplateData{
+		Infrastructure: cluster.Infrastructure,
+		Platform:       cluster.PlatformType(),
+		Region:         cluster.Region(),
+		ClusterName:    cluster.ClusterName(),
+		BaseDomain:     cluster.BaseDomain,
+		ClusterID:      cluster.ClusterID,
+		Tokens:         tokens,
+	}
+}
+
+// Check if the string contains a template action.
+func containsTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
+
+// Render every eligible string leaf that contains a template action as a Go template.
+func RenderTemplates(section map[string]interface{}, data *TemplateData, filter *PathFilter) error {
+	_, err := TransformStringLeaves(section, "", func(path, value string) (string, error) {
+		if !filter.Allows(path) || !containsTemplate(value) {
+			return value, nil
+		}
+		tmpl, err := template.New(path).Option("missingkey=error").Funcs(templateFuncs).Parse(value)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse template at %s: %w", path, err)
+		}
+		var rendered bytes.Buffer
+		if err = tmpl.Execute(&rendered, data); err != nil {
+			return "", fmt.Errorf("failed to render template at %s: %w", path, err)
+		}
+		return rendered.String(), nil
+	})
+	return err
+}
+
+// Check if any of the eligible string leaves contains a template action that hasn't been rendered.
+func ContainsTemplates(section map[string]interface{}, filter *PathFilter) bool {
+	found := false
+	TransformStringLeaves(section, "", func(path, value string) (string, error) {
+		found = found || (filter.Allows(path) && containsTemplate(value))
+		return value, nil
+	})
+	return found
+}
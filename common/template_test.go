@@ -0,0 +1,134 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+
+	configapi "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	jsonpatch "gomodules.xyz/jsonpatch/v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestIsTemplateRenderingEnabled(t *testing.T) {
+	assert := assert.New(t)
+
+	var input *unstructured.Unstructured
+
+	input = &unstructured.Unstructured{}
+	assert.Equal(false, IsTemplateRenderingEnabled(input))
+
+	input = &unstructured.Unstructured{}
+	input.SetAnnotations(map[string]string{
+		AnnotationTemplate: "true",
+	})
+	assert.Equal(true, IsTemplateRenderingEnabled(input))
+}
+
+func TestRenderTemplates(t *testing.T) {
+	assert := assert.New(t)
+
+	cluster := &ClusterInfo{
+		Infrastructure: configapi.InfrastructureStatus{
+			InfrastructureName: "mycluster-jfnx7",
+			PlatformStatus: &configapi.PlatformStatus{
+				Type: configapi.AWSPlatformType,
+				AWS:  &configapi.AWSPlatformStatus{Region: "us-east-2"},
+			},
+		},
+	}
+	data := NewTemplateData(cluster, map[string]string{"SUBNET": "subnet-0a1b2c"})
+
+	section := map[string]interface{}{
+		"region": "{{ .Infrastructure.PlatformStatus.AWS.Region }}",
+		"list": []interface{}{
+			`{{ if eq .Platform "AWS" }}aws{{ else }}other{{ end }}`,
+			`{{ .Tokens.SUBNET }}`,
+			`{{ .ClusterID | default "none" }}`,
+		},
+		"plain": "INFRANAME",
+	}
+	err := RenderTemplates(section, data, nil)
+	assert.Equal(nil, err)
+	assert.Equal(map[string]interface{}{
+		"region": "us-east-2",
+		"list":   []interface{}{"aws", "subnet-0a1b2c", "none"},
+		"plain":  "INFRANAME",
+	}, section)
+	assert.Equal(false, ContainsTemplates(section, nil))
+
+	section = map[string]interface{}{"spec": map[string]interface{}{"broken": "{{ .Unknown }}"}}
+	assert.Equal(true, ContainsTemplates(section, nil))
+	err = RenderTemplates(section, data, nil)
+	assert.Contains(err.Error(), "/spec/broken")
+}
+
+func TestCreatePatchWithTemplates(t *testing.T) {
+	assert := assert.New(t)
+
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machineSet.SetAnnotations(map[string]string{AnnotationTemplate: "true"})
+	unstructured.SetNestedField(machineSet.UnstructuredContent(), "INFRANAME-{{ .Region }}", "spec", "template", "spec", "providerSpec", "value", "subnet")
+
+	cluster := &ClusterInfo{
+		Infrastructure: configapi.InfrastructureStatus{
+			PlatformStatus: &configapi.PlatformStatus{
+				GCP: &configapi.GCPPlatformStatus{Region: "us-central1"},
+			},
+		},
+	}
+	patchBytes, err := CreatePatch(logger, machineSet, map[string]string{"INFRANAME": "mycluster"}, cluster)
+	assert.Equal(nil, err)
+
+	patch := []jsonpatch.Operation{}
+	err = json.Unmarshal(patchBytes, &patch)
+	assert.Equal(nil, err)
+	assert.ElementsMatch([]jsonpatch.Operation{
+		{Operation: "replace",
+			Path:  "/spec/template/spec/providerSpec/value/subnet",
+			Value: "mycluster-us-central1"}}, patch)
+}
+
+func TestCreatePatchWithTemplatesAndBraces(t *testing.T) {
+	assert := assert.New(t)
+
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machineSet.SetAnnotations(map[string]string{AnnotationTemplate: "true", AnnotationTokenSyntax: TokenSyntaxBraces})
+	unstructured.SetNestedField(machineSet.UnstructuredContent(), "{{INFRANAME}}-{{ .Region }}", "spec", "template", "spec", "providerSpec", "value", "subnet")
+
+	// The conflict is reported instead of a template parse error
+	_, err := CreatePatch(logger, machineSet, map[string]string{"INFRANAME": "mycluster"}, &ClusterInfo{})
+	assert.NotNil(err)
+	assert.Contains(err.Error(), AnnotationTokenSyntax)
+}
+
+func TestCreatePatchKeepsLastAppliedConfiguration(t *testing.T) {
+	assert := assert.New(t)
+
+	// kubectl stores the MachineSet as JSON, the quotes in the template are escaped
+	lastApplied := `{"spec":{"template":{"spec":{"providerSpec":{"value":{"subnet":"INFRANAME-{{ default \"x\" .Region }}"}}}}}}`
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machineSet.SetAnnotations(map[string]string{
+		AnnotationTemplate: "true",
+		"kubectl.kubernetes.io/last-applied-configuration": lastApplied,
+	})
+	unstructured.SetNestedField(machineSet.UnstructuredContent(), `INFRANAME-{{ default "x" .Region }}`, "spec", "template", "spec", "providerSpec", "value", "subnet")
+
+	cluster := &ClusterInfo{
+		Infrastructure: configapi.InfrastructureStatus{
+			PlatformStatus: &configapi.PlatformStatus{
+				GCP: &configapi.GCPPlatformStatus{Region: "us-central1"},
+			},
+		},
+	}
+	patchBytes, err := CreatePatch(logger, machineSet, map[string]string{"INFRANAME": "mycluster"}, cluster)
+	assert.Equal(nil, err)
+
+	patch := []jsonpatch.Operation{}
+	err = json.Unmarshal(patchBytes, &patch)
+	assert.Equal(nil, err)
+	assert.ElementsMatch([]jsonpatch.Operation{
+		{Operation: "replace",
+			Path:  "/spec/template/spec/providerSpec/value/subnet",
+			Value: "mycluster-us-central1"}}, patch)
+}
@@ -0,0 +1,74 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const truncateHashLength = 5
+
+var invalidLabelValueChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// Transformation functions that can be applied to a token value, for example ${INFRANAME|lower}.
+// A function may take a single argument, for example ${INFRANAME|truncate:20}.
+var tokenTransforms = map[string]func(value, arg string) (string, bool){
+	TransformLower: func(value, arg string) (string, bool) {
+		return strings.ToLower(value), arg == ""
+	},
+	TransformTruncate: func(value, arg string) (string, bool) {
+		length, err := strconv.Atoi(arg)
+		if err != nil || length < 1 {
+			return value, false
+		}
+		return truncateWithHash(value, length), true
+	},
+	TransformSanitize: func(value, arg string) (string, bool) {
+		return sanitizeLabelValue(value), arg == ""
+	},
+}
+
+// Shorten the value to at most the given length. To keep the truncated values unique, the end of the
+// value is replaced with a hash of the complete value.
+func truncateWithHash(value string, length int) string {
+	if len(value) <= length {
+		return value
+	}
+	if length <= truncateHashLength+1 {
+		return value[:length]
+	}
+	sum := sha256.Sum256([]byte(value))
+	hash := hex.EncodeToString(sum[:])[:truncateHashLength]
+	prefix := strings.TrimRight(value[:length-truncateHashLength-1], "-_.")
+	return prefix + "-" + hash
+}
+
+// Replace the characters that are not allowed in a label value and trim the non-alphanumeric characters
+// at both ends.
+func sanitizeLabelValue(value string) string {
+	value = invalidLabelValueChars.ReplaceAllString(value, "-")
+	return strings.Trim(value, "-_.")
+}
+
+// Apply the chain of transformations written as |name:arg|name:arg... to the value. Returns false if
+// any of the transformations is unknown or has an invalid argument.
+func applyTransforms(value, chain string) (string, bool) {
+	for _, transform := range strings.Split(chain, "|")[1:] {
+		parts := strings.SplitN(strings.TrimSpace(transform), ":", 2)
+		fn, known := tokenTransforms[parts[0]]
+		if !known {
+			return value, false
+		}
+		arg := ""
+		if len(parts) == 2 {
+			arg = parts[1]
+		}
+		var ok bool
+		if value, ok = fn(value, arg); !ok {
+			return value, false
+		}
+	}
+	return value, true
+}
@@ -0,0 +1,61 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateWithHash(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("cluster-abc", truncateWithHash("cluster-abc", 20))
+
+	long := strings.Repeat("a", 70)
+	truncated := truncateWithHash(long, 63)
+	assert.Len(truncated, 63)
+	assert.True(strings.HasPrefix(truncated, strings.Repeat("a", 57)+"-"))
+
+	// Different values sharing a long prefix stay unique
+	assert.NotEqual(truncateWithHash(long+"x", 63), truncateWithHash(long+"y", 63))
+
+	assert.Equal("abc", truncateWithHash("abcdefgh", 3))
+}
+
+func TestSanitizeLabelValue(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("cluster-abc", sanitizeLabelValue("cluster-abc"))
+	assert.Equal("us-east-1a-worker", sanitizeLabelValue("us east/1a:worker"))
+	assert.Equal("https-example.com", sanitizeLabelValue("https://example.com/"))
+	assert.Equal("a_b", sanitizeLabelValue("-a_b."))
+}
+
+func TestApplyTransforms(t *testing.T) {
+	assert := assert.New(t)
+
+	var value string
+	var ok bool
+
+	value, ok = applyTransforms("Cluster-ABC", "")
+	assert.True(ok)
+	assert.Equal("Cluster-ABC", value)
+
+	value, ok = applyTransforms("Cluster-ABC", "|lower")
+	assert.True(ok)
+	assert.Equal("cluster-abc", value)
+
+	value, ok = applyTransforms("My Cluster", " | sanitize | lower | truncate:5")
+	assert.True(ok)
+	assert.Equal("my-cl", value)
+
+	_, ok = applyTransforms("cluster", "|upper")
+	assert.False(ok)
+
+	_, ok = applyTransforms("cluster", "|truncate")
+	assert.False(ok)
+
+	_, ok = applyTransforms("cluster", "|lower:5")
+	assert.False(ok)
+}
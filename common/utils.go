@@ -1,12 +1,16 @@
 package common
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"sort"
+	"strings"
 
 	"github.com/go-logr/logr"
+	"github.com/noseka1/gitops-friendly-machinesets-operator/resolvers"
 	jsonpatch "gomodules.xyz/jsonpatch/v2"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 func IsObjectReconciliationEnabled(obj *unstructured.Unstructured) bool {
@@ -15,6 +19,14 @@ func IsObjectReconciliationEnabled(obj *unstructured.Unstructured) bool {
 	return enabledFound && enabledString == "true"
 }
 
+// Check if the object carries the label that makes the API server send it to the operator's webhooks.
+// Objects without the label are only processed by the controllers.
+func IsWebhookLabelPresent(obj *unstructured.Unstructured) bool {
+	labels := obj.GetLabels()
+	enabledString, enabledFound := labels[LabelEnabled]
+	return enabledFound && enabledString == "true"
+}
+
 func EvaluateAnnotations(logger logr.Logger, obj *unstructured.Unstructured) (bool, string) {
 	if !IsObjectReconciliationEnabled(obj) {
 		logger.V(2).Info("Skipping object. Annotation \"" + AnnotationEnabled + "\" that allows patching was not found on this object.")
@@ -31,33 +43,165 @@ func EvaluateAnnotations(logger logr.Logger, obj *unstructured.Unstructured) (bo
 	return true, tokenName
 }
 
-func MarshalObjectSections(logger logr.Logger, obj *unstructured.Unstructured) ([]byte, error) {
-	section := unstructured.Unstructured{Object: map[string]interface{}{}}
+// Build the dictionary of tokens that are going to be replaced in the object. It consists of the built-in
+// tokens, the tokens returned by the registered resolvers and the tokens read from the ConfigMaps and Secrets
+// referenced by the object. Built-in and resolver tokens take precedence.
+func ResolveTokens(ctx context.Context, logger logr.Logger, reader client.Reader, obj *unstructured.Unstructured, tokenName string, cluster *ClusterInfo, resolvers *resolvers.TokenResolverRegistry) (map[string]string, error) {
+	tokens, err := LoadTokenSources(ctx, logger, reader, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	resolverTokens, err := resolvers.ResolveTokens(ctx, logger, obj, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range ResolveBuiltinTokens(logger, obj, tokenName, cluster, resolverTokens) {
+		if _, found := tokens[name]; found {
+			logger.Info("Token \"" + name + "\" from token sources is shadowed by the built-in token of the same name.")
+		}
+		tokens[name] = value
+	}
+
+	return tokens, nil
+}
+
+// Build the dictionary of built-in tokens. The infrastructure name token is always included. Additional
+// built-in tokens and the tokens returned by the resolvers can be selected by listing them in the tokens
+// annotation.
+func ResolveBuiltinTokens(logger logr.Logger, obj *unstructured.Unstructured, tokenName string, cluster *ClusterInfo, resolverTokens map[string]string) map[string]string {
+	builtinTokens := BuiltinTokens(cluster)
+	tokens := map[string]string{tokenName: builtinTokens[DefaultTokenName]}
+
+	annotations := obj.GetAnnotations()
+	selectedTokens, selectedTokensFound := annotations[AnnotationTokens]
+	if !selectedTokensFound {
+		return tokens
+	}
+
+	for name, value := range resolverTokens {
+		if _, found := builtinTokens[name]; found {
+			logger.Info("Ignoring resolved token \"" + name + "\" as it clashes with a built-in token.")
+			continue
+		}
+		builtinTokens[name] = value
+	}
+
+	for _, name := range strings.Split(selectedTokens, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		value, known := builtinTokens[name]
+		if !known {
+			logger.Info("Ignoring unknown token \"" + name + "\" listed in annotation \"" + AnnotationTokens + "\".")
+			continue
+		}
+		if value == "" {
+			logger.V(1).Info("Ignoring token \"" + name + "\" as it has no value on this cluster.")
+			continue
+		}
+		tokens[name] = value
+	}
+
+	return tokens
+}
+
+// Sorted list of token names, handy for logging.
+func TokenNames(tokens map[string]string) []string {
+	names := make([]string, 0, len(tokens))
+	for name := range tokens {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Extract a copy of the object sections that are subject to token replacement.
+func ExtractObjectSections(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	section := &unstructured.Unstructured{Object: map[string]interface{}{}}
 
 	labelsField := obj.GetLabels()
 	section.SetLabels(labelsField)
 
+	annotationsField := obj.GetAnnotations()
+	section.SetAnnotations(annotationsField)
+
 	specField, _, _ := unstructured.NestedFieldNoCopy(obj.UnstructuredContent(), FieldSpec)
 	unstructured.SetNestedField(section.UnstructuredContent(), specField, FieldSpec)
 
-	sectionBytes, err := section.MarshalJSON()
+	return section
+}
+
+func MarshalObjectSections(logger logr.Logger, obj *unstructured.Unstructured) ([]byte, error) {
+	sectionBytes, err := ExtractObjectSections(obj).MarshalJSON()
 	if err != nil {
 		logger.Error(err, "Failed to marshal object sections to JSON")
 	}
 	return sectionBytes, err
 }
-func CreatePatch(logger logr.Logger, machineSet *unstructured.Unstructured, tokenName, infrastructureName string) ([]byte, error) {
-	// Extract MachineSet sections that are going to be patched
-	machineSetBytes, err := MarshalObjectSections(logger, machineSet)
+
+// Render the templates and replace the tokens in the MachineSet sections. Also applies the vSphere
+// failure domain referenced by the MachineSet. Returns the updated sections, the MachineSet itself is
+// left unchanged.
+func SubstituteTokens(logger logr.Logger, machineSet *unstructured.Unstructured, tokens map[string]string, cluster *ClusterInfo) (*unstructured.Unstructured, error) {
+	// The templates would consume the tokens written in the braces syntax
+	if errs := ValidateTokenSyntax(machineSet); len(errs) > 0 {
+		err := errs.ToAggregate()
+		logger.Error(err, "Conflicting token syntax.")
+		return nil, err
+	}
+
+	section := ExtractObjectSections(machineSet)
+	substitution := NewSubstitution(logger, machineSet, tokens)
+
+	// Render the templates if enabled
+	if IsTemplateRenderingEnabled(machineSet) {
+		err := RenderTemplates(section.UnstructuredContent(), NewTemplateData(cluster, tokens), substitution.Filter)
+		if err != nil {
+			logger.Error(err, "Failed to render templates.")
+			return nil, err
+		}
+	}
+
+	// Replace the tokens in the string values and remember where tokens have been written literally.
+	// The Machines inherit the literal paths from their owner MachineSet
+	literalPaths := substitution.ReplaceTokens(section.UnstructuredContent())
+	if machineSet.GetKind() != KindMachine {
+		setLiteralPaths(section.UnstructuredContent(), literalPaths)
+	}
+
+	// Fill in the placement from the vSphere failure domain
+	SetVSphereFailureDomain(logger, machineSet, section, cluster)
+
+	return section, nil
+}
+
+func CreatePatch(logger logr.Logger, machineSet *unstructured.Unstructured, tokens map[string]string, cluster *ClusterInfo) ([]byte, error) {
+	section, err := SubstituteTokens(logger, machineSet, tokens, cluster)
+	if err != nil {
+		return []byte{}, err
+	}
+	return CreateSectionsPatch(logger, machineSet, section)
+}
+
+// Compute the JSON patch that turns the sections of the object into the updated sections.
+func CreateSectionsPatch(logger logr.Logger, obj *unstructured.Unstructured, updatedSection *unstructured.Unstructured) ([]byte, error) {
+	// Extract object sections that are going to be patched
+	objBytes, err := MarshalObjectSections(logger, obj)
 	if err != nil {
 		return []byte{}, err
 	}
 
-	// Replace the token in the serialized JSON
-	machineSetUpdatedBytes := bytes.ReplaceAll(machineSetBytes, []byte(tokenName), []byte(infrastructureName))
+	objUpdatedBytes, err := updatedSection.MarshalJSON()
+	if err != nil {
+		logger.Error(err, "Failed to marshal object sections to JSON")
+		return []byte{}, err
+	}
 
 	// Compute the JSON patch
-	jsonPatch, err := jsonpatch.CreatePatch(machineSetBytes, machineSetUpdatedBytes)
+	jsonPatch, err := jsonpatch.CreatePatch(objBytes, objUpdatedBytes)
 	if err != nil {
 		logger.Error(err, "Failed to generate patch.")
 		return []byte{}, err
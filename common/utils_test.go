@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/go-logr/logr"
+	configapi "github.com/openshift/api/config/v1"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap/zapcore"
 	jsonpatch "gomodules.xyz/jsonpatch/v2"
@@ -86,7 +87,7 @@ func TestCreatePatch(t *testing.T) {
 	unstructured.SetNestedField(machineSet.UnstructuredContent(), "INFRANAME", "spec", "selector", "matchLabels", "machine.openshift.io/cluster-api-cluster")
 	unstructured.SetNestedField(machineSet.UnstructuredContent(), "INFRANAME-worker-us-east-2c", "spec", "selector", "matchLabels", "machine.openshift.io/cluster-api-machineset")
 
-	patchBytes, err := CreatePatch(logger, machineSet, "INFRANAME", "MYCLUSTER")
+	patchBytes, err := CreatePatch(logger, machineSet, map[string]string{"INFRANAME": "MYCLUSTER"}, nil)
 	assert.Equal(nil, err)
 
 	patch := []jsonpatch.Operation{}
@@ -105,3 +106,119 @@ func TestCreatePatch(t *testing.T) {
 	assert.Equal(nil, err)
 	assert.ElementsMatch(expectedPatch, patch)
 }
+
+func TestCreatePatchMultipleTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	unstructured.SetNestedField(machineSet.UnstructuredContent(), "INFRANAME-REGION", "metadata", "labels", "mylabel")
+	unstructured.SetNestedField(machineSet.UnstructuredContent(), "INFRANAMEX", "spec", "value")
+
+	patchBytes, err := CreatePatch(logger, machineSet, map[string]string{
+		"INFRANAME":  "MYCLUSTER",
+		"INFRANAMEX": "OTHER",
+		"REGION":     "us-east-2"}, nil)
+	assert.Equal(nil, err)
+
+	patch := []jsonpatch.Operation{}
+	err = json.Unmarshal(patchBytes, &patch)
+
+	expectedPatch := []jsonpatch.Operation{
+		{Operation: "replace",
+			Path:  "/metadata/labels/mylabel",
+			Value: "MYCLUSTER-us-east-2"},
+		{Operation: "replace",
+			Path:  "/spec/value",
+			Value: "OTHER"}}
+	assert.Equal(nil, err)
+	assert.ElementsMatch(expectedPatch, patch)
+}
+
+func TestResolveBuiltinTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	cluster := &ClusterInfo{
+		Infrastructure: configapi.InfrastructureStatus{
+			InfrastructureName: "mycluster-jfnx7",
+			PlatformStatus: &configapi.PlatformStatus{
+				Type: configapi.AWSPlatformType,
+				AWS:  &configapi.AWSPlatformStatus{Region: "us-east-2"},
+			},
+		},
+		BaseDomain: "mycluster.example.com",
+	}
+
+	var input *unstructured.Unstructured
+
+	input = &unstructured.Unstructured{}
+	assert.Equal(map[string]string{"INFRANAME": "mycluster-jfnx7"}, ResolveBuiltinTokens(logger, input, "INFRANAME", cluster, nil))
+
+	input = &unstructured.Unstructured{}
+	input.SetAnnotations(map[string]string{
+		AnnotationTokens: "REGION, PLATFORM,CLUSTERNAME,CLUSTERID,UNKNOWN",
+	})
+	assert.Equal(map[string]string{
+		"mytoken":     "mycluster-jfnx7",
+		"REGION":      "us-east-2",
+		"PLATFORM":    "AWS",
+		"CLUSTERNAME": "mycluster"}, ResolveBuiltinTokens(logger, input, "mytoken", cluster, nil))
+}
+
+func TestCreatePatchStructureAware(t *testing.T) {
+	assert := assert.New(t)
+
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machineSet.SetAnnotations(map[string]string{
+		AnnotationIncludePaths: "/spec/template",
+		AnnotationExcludePaths: "/spec/template/spec/providerSpec/value/tags",
+	})
+	unstructured.SetNestedField(machineSet.UnstructuredContent(), "INFRANAME", "metadata", "labels", "machine.openshift.io/cluster-api-cluster")
+	unstructured.SetNestedField(machineSet.UnstructuredContent(), "INFRANAME", "spec", "template", "metadata", "labels", "machine.openshift.io/cluster-api-cluster")
+	unstructured.SetNestedField(machineSet.UnstructuredContent(), "INFRANAME", "spec", "template", "spec", "providerSpec", "value", "tags", "INFRANAME")
+	unstructured.SetNestedField(machineSet.UnstructuredContent(), "has \"quotes\"", "spec", "template", "spec", "providerSpec", "value", "INFRANAME")
+
+	patchBytes, err := CreatePatch(logger, machineSet, map[string]string{"INFRANAME": "MY\"CLUSTER"}, nil)
+	assert.Equal(nil, err)
+
+	patch := []jsonpatch.Operation{}
+	err = json.Unmarshal(patchBytes, &patch)
+
+	expectedPatch := []jsonpatch.Operation{
+		{Operation: "replace",
+			Path:  "/spec/template/metadata/labels/machine.openshift.io~1cluster-api-cluster",
+			Value: "MY\"CLUSTER"}}
+	assert.Equal(nil, err)
+	assert.ElementsMatch(expectedPatch, patch)
+}
+
+func TestCreatePatchAnnotations(t *testing.T) {
+	assert := assert.New(t)
+
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machineSet.SetAnnotations(map[string]string{
+		"autoscaling.openshift.io/machineautoscaler": "openshift-machine-api/MYTOKEN-worker-us-east-2a",
+		AnnotationEnabled:   "true",
+		AnnotationTokenName: "MYTOKEN",
+	})
+	unstructured.SetNestedStringMap(machineSet.UnstructuredContent(), map[string]string{
+		"example.com/owner": "MYTOKEN",
+		AnnotationEnabled:   "true",
+		AnnotationTokenName: "MYTOKEN",
+	}, "spec", "template", "metadata", "annotations")
+
+	patchBytes, err := CreatePatch(logger, machineSet, map[string]string{"MYTOKEN": "mycluster"}, nil)
+	assert.Equal(nil, err)
+
+	patch := []jsonpatch.Operation{}
+	err = json.Unmarshal(patchBytes, &patch)
+
+	expectedPatch := []jsonpatch.Operation{
+		{Operation: "replace",
+			Path:  "/metadata/annotations/autoscaling.openshift.io~1machineautoscaler",
+			Value: "openshift-machine-api/mycluster-worker-us-east-2a"},
+		{Operation: "replace",
+			Path:  "/spec/template/metadata/annotations/example.com~1owner",
+			Value: "mycluster"}}
+	assert.Equal(nil, err)
+	assert.ElementsMatch(expectedPatch, patch)
+}
@@ -0,0 +1,128 @@
+package common
+
+import (
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Locations of the labels within the MachineSet sections
+var machineSetLabelFields = [][]string{
+	{FieldMetadata, FieldLabels},
+	{FieldSpec, FieldSelector, FieldMatchLabels},
+	{FieldSpec, FieldTemplate, FieldMetadata, FieldLabels},
+	{FieldSpec, FieldTemplate, FieldSpec, FieldMetadata, FieldLabels},
+}
+
+// Validate all label keys and values found in the object sections after the tokens have been
+// replaced. Each error points at the offending label, for example spec.template.metadata.labels[app].
+func ValidateLabels(section *unstructured.Unstructured) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for _, fields := range machineSetLabelFields {
+		labels, found, err := unstructured.NestedStringMap(section.UnstructuredContent(), fields...)
+		fldPath := field.NewPath(fields[0], fields[1:]...)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath, nil, err.Error()))
+			continue
+		}
+		if !found {
+			continue
+		}
+		keys := make([]string, 0, len(labels))
+		for key := range labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := labels[key]
+			for _, msg := range validation.IsQualifiedName(key) {
+				allErrs = append(allErrs, field.Invalid(fldPath.Key(key), key, "invalid label key: "+msg))
+			}
+			for _, msg := range validation.IsValidLabelValue(value) {
+				allErrs = append(allErrs, field.Invalid(fldPath.Key(key), value, "invalid label value: "+msg))
+			}
+		}
+	}
+	return allErrs
+}
+
+// Validate a MachineSet enabled for reconciliation after it has been mutated. Returns the errors of all
+// checks below.
+func ValidateMachineSet(section *unstructured.Unstructured, substitution *Substitution) field.ErrorList {
+	allErrs := field.ErrorList{}
+	allErrs = append(allErrs, ValidateTokenSyntax(section)...)
+	allErrs = append(allErrs, ValidateNoTokens(section, substitution)...)
+	allErrs = append(allErrs, ValidateSelector(section)...)
+	allErrs = append(allErrs, ValidateRoleLabel(section)...)
+	allErrs = append(allErrs, ValidateLabels(section)...)
+	return allErrs
+}
+
+// The braces token syntax and the Go templates use the same delimiters. A {{REGION}} token would be
+// parsed as a template action, the two cannot be enabled on the same object.
+func ValidateTokenSyntax(obj *unstructured.Unstructured) field.ErrorList {
+	allErrs := field.ErrorList{}
+	syntax := obj.GetAnnotations()[AnnotationTokenSyntax]
+	if IsTemplateRenderingEnabled(obj) && syntax == TokenSyntaxBraces {
+		allErrs = append(allErrs, field.Invalid(field.NewPath(FieldMetadata, FieldAnnotations).Key(AnnotationTokenSyntax),
+			syntax, "the \""+TokenSyntaxBraces+"\" token syntax cannot be combined with template rendering, use the \""+TokenSyntaxDollar+"\" token syntax instead"))
+	}
+	return allErrs
+}
+
+// Report each field that still contains tokens.
+func ValidateNoTokens(section *unstructured.Unstructured, substitution *Substitution) field.ErrorList {
+	found := substitution.FindTokens(section.UnstructuredContent())
+	paths := make([]string, 0, len(found))
+	for path := range found {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	allErrs := field.ErrorList{}
+	for _, path := range paths {
+		allErrs = append(allErrs, field.Invalid(JSONPointerToFieldPath(path), found[path], "tokens have not been replaced"))
+	}
+	return allErrs
+}
+
+func ValidateSelector(section *unstructured.Unstructured) field.ErrorList {
+	allErrs := field.ErrorList{}
+	selectorPath := field.NewPath(FieldSpec, FieldSelector)
+
+	selectorField, found, _ := unstructured.NestedMap(section.UnstructuredContent(), FieldSpec, FieldSelector)
+	if !found {
+		return allErrs
+	}
+	labelSelector := &metav1.LabelSelector{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(selectorField, labelSelector); err != nil {
+		return append(allErrs, field.Invalid(selectorPath, selectorField, err.Error()))
+	}
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return append(allErrs, field.Invalid(selectorPath, selectorField, err.Error()))
+	}
+
+	templateLabels, _, _ := unstructured.NestedStringMap(section.UnstructuredContent(), FieldSpec, FieldTemplate, FieldMetadata, FieldLabels)
+	if !selector.Matches(labels.Set(templateLabels)) {
+		allErrs = append(allErrs, field.Invalid(field.NewPath(FieldSpec, FieldTemplate, FieldMetadata, FieldLabels),
+			templateLabels, "`selector` does not match template `labels`"))
+	}
+	return allErrs
+}
+
+func ValidateRoleLabel(section *unstructured.Unstructured) field.ErrorList {
+	allErrs := field.ErrorList{}
+	templateLabels, _, _ := unstructured.NestedStringMap(section.UnstructuredContent(), FieldSpec, FieldTemplate, FieldMetadata, FieldLabels)
+	if templateLabels[LabelMachineRole] == "" {
+		allErrs = append(allErrs, field.Required(
+			field.NewPath(FieldSpec, FieldTemplate, FieldMetadata, FieldLabels).Key(LabelMachineRole),
+			"the Machines must be assigned a role"))
+	}
+	return allErrs
+}
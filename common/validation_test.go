@@ -0,0 +1,70 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestValidateLabels(t *testing.T) {
+	assert := assert.New(t)
+
+	section := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	section.SetLabels(map[string]string{"machine.openshift.io/cluster-api-cluster": "cluster-abc"})
+	unstructured.SetNestedStringMap(section.Object, map[string]string{"app": "worker"},
+		FieldSpec, FieldSelector, FieldMatchLabels)
+	assert.Empty(ValidateLabels(section))
+
+	unstructured.SetNestedStringMap(section.Object, map[string]string{"app": "Cluster ABC", "bad key!": "x"},
+		FieldSpec, FieldTemplate, FieldMetadata, FieldLabels)
+	errs := ValidateLabels(section)
+	assert.Len(errs, 2)
+	assert.Equal("spec.template.metadata.labels[app]", errs[0].Field)
+	assert.Equal("spec.template.metadata.labels[bad key!]", errs[1].Field)
+}
+
+func TestValidateMachineSet(t *testing.T) {
+	assert := assert.New(t)
+
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	unstructured.SetNestedStringMap(machineSet.Object, map[string]string{"app": "worker"},
+		FieldSpec, FieldSelector, FieldMatchLabels)
+	unstructured.SetNestedStringMap(machineSet.Object, map[string]string{"app": "worker", LabelMachineRole: "worker"},
+		FieldSpec, FieldTemplate, FieldMetadata, FieldLabels)
+	unstructured.SetNestedField(machineSet.Object, "mycluster-worker-profile", "spec", "template", "spec", "providerSpec", "value", "iamInstanceProfile", "id")
+	substitution := NewSubstitution(logger, machineSet, map[string]string{"INFRANAME": "mycluster"})
+	assert.Empty(ValidateMachineSet(ExtractObjectSections(machineSet), substitution))
+
+	// Remaining token
+	unstructured.SetNestedField(machineSet.Object, "INFRANAME-worker-profile", "spec", "template", "spec", "providerSpec", "value", "iamInstanceProfile", "id")
+	errs := ValidateMachineSet(ExtractObjectSections(machineSet), substitution)
+	assert.Len(errs, 1)
+	assert.Equal("spec.template.spec.providerSpec.value.iamInstanceProfile.id", errs[0].Field)
+
+	// Selector mismatch and missing role label
+	unstructured.SetNestedField(machineSet.Object, "mycluster-worker-profile", "spec", "template", "spec", "providerSpec", "value", "iamInstanceProfile", "id")
+	unstructured.SetNestedStringMap(machineSet.Object, map[string]string{"app": "infra"},
+		FieldSpec, FieldTemplate, FieldMetadata, FieldLabels)
+	errs = ValidateMachineSet(ExtractObjectSections(machineSet), substitution)
+	assert.Len(errs, 2)
+	assert.Equal("spec.template.metadata.labels", errs[0].Field)
+	assert.Equal("spec.template.metadata.labels[machine.openshift.io/cluster-api-machine-role]", errs[1].Field)
+}
+
+func TestValidateTokenSyntax(t *testing.T) {
+	assert := assert.New(t)
+
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machineSet.SetAnnotations(map[string]string{AnnotationTemplate: "true", AnnotationTokenSyntax: TokenSyntaxDollar})
+	assert.Empty(ValidateTokenSyntax(machineSet))
+
+	machineSet.SetAnnotations(map[string]string{AnnotationTokenSyntax: TokenSyntaxBraces})
+	assert.Empty(ValidateTokenSyntax(machineSet))
+
+	// Templates and the braces syntax use the same delimiters
+	machineSet.SetAnnotations(map[string]string{AnnotationTemplate: "true", AnnotationTokenSyntax: TokenSyntaxBraces})
+	errs := ValidateTokenSyntax(machineSet)
+	assert.Len(errs, 1)
+	assert.Equal("metadata.annotations["+AnnotationTokenSyntax+"]", errs[0].Field)
+}
@@ -0,0 +1,89 @@
+package common
+
+import (
+	"github.com/go-logr/logr"
+	"github.com/noseka1/gitops-friendly-machinesets-operator/resolvers"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Read the vSphere failure domains from the Infrastructure object.
+func ParseVSphereFailureDomains(infrastructure *unstructured.Unstructured) ([]resolvers.VSphereFailureDomain, error) {
+	failureDomainsField, found, err := unstructured.NestedSlice(infrastructure.UnstructuredContent(),
+		FieldSpec, "platformSpec", "vsphere", "failureDomains")
+	if err != nil || !found {
+		return nil, err
+	}
+
+	failureDomains := []resolvers.VSphereFailureDomain{}
+	for _, field := range failureDomainsField {
+		fieldMap, ok := field.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		failureDomain := resolvers.VSphereFailureDomain{}
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(fieldMap, &failureDomain); err != nil {
+			return nil, err
+		}
+		failureDomains = append(failureDomains, failureDomain)
+	}
+	return failureDomains, nil
+}
+
+// Fill in the workspace, network devices and template in the providerSpec found in the MachineSet or Machine
+// sections using the failure domain named in the failure-domain annotation. Returns false if the object
+// doesn't reference a failure domain or the failure domain doesn't exist.
+func SetVSphereFailureDomain(logger logr.Logger, machineSet *unstructured.Unstructured, section *unstructured.Unstructured, cluster *ClusterInfo) bool {
+	name, found := machineSet.GetAnnotations()[AnnotationFailureDomain]
+	if !found || cluster == nil {
+		return false
+	}
+
+	failureDomain := cluster.FindVSphereFailureDomain(name)
+	if failureDomain == nil {
+		logger.Info("vSphere failure domain \"" + name + "\" listed in annotation \"" + AnnotationFailureDomain + "\" not found.")
+		return false
+	}
+
+	valueFields := providerSpecValuePath(machineSet)
+	providerSpecValue, found, _ := unstructured.NestedMap(section.UnstructuredContent(), valueFields...)
+	if !found {
+		logger.Info(machineSet.GetKind() + " has no providerSpec. Cannot apply the vSphere failure domain.")
+		return false
+	}
+
+	topology := failureDomain.Topology
+
+	// Default to the folder and template created by the installer
+	folder := topology.Folder
+	if folder == "" {
+		folder = "/" + topology.Datacenter + "/vm/" + cluster.InfrastructureName()
+	}
+	template := topology.Template
+	if template == "" {
+		template = cluster.InfrastructureName() + "-rhcos"
+	}
+
+	workspace := map[string]interface{}{
+		"server":     failureDomain.Server,
+		"datacenter": topology.Datacenter,
+		"datastore":  topology.Datastore,
+		"folder":     folder,
+	}
+	if topology.ResourcePool != "" {
+		workspace["resourcePool"] = topology.ResourcePool
+	}
+	providerSpecValue["workspace"] = workspace
+
+	devices := []interface{}{}
+	for _, network := range topology.Networks {
+		devices = append(devices, map[string]interface{}{"networkName": network})
+	}
+	providerSpecValue["network"] = map[string]interface{}{"devices": devices}
+
+	providerSpecValue["template"] = template
+
+	unstructured.SetNestedMap(section.UnstructuredContent(), providerSpecValue, valueFields...)
+	logger.V(1).Info("vSphere failure domain \"" + name + "\" applied.")
+	return true
+}
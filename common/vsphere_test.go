@@ -0,0 +1,104 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/noseka1/gitops-friendly-machinesets-operator/resolvers"
+	configapi "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestParseVSphereFailureDomains(t *testing.T) {
+	assert := assert.New(t)
+
+	infrastructure := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	failureDomains, err := ParseVSphereFailureDomains(infrastructure)
+	assert.Nil(err)
+	assert.Empty(failureDomains)
+
+	unstructured.SetNestedSlice(infrastructure.Object, []interface{}{
+		map[string]interface{}{
+			"name":   "us-east-1",
+			"region": "us-east",
+			"zone":   "us-east-1a",
+			"server": "vcenter.example.com",
+			"topology": map[string]interface{}{
+				"datacenter":     "dc1",
+				"computeCluster": "/dc1/host/cluster1",
+				"networks":       []interface{}{"VM Network"},
+				"datastore":      "/dc1/datastore/ds1",
+				"resourcePool":   "/dc1/host/cluster1/Resources",
+			},
+		},
+	}, "spec", "platformSpec", "vsphere", "failureDomains")
+	failureDomains, err = ParseVSphereFailureDomains(infrastructure)
+	assert.Nil(err)
+	assert.Equal([]resolvers.VSphereFailureDomain{{
+		Name:   "us-east-1",
+		Region: "us-east",
+		Zone:   "us-east-1a",
+		Server: "vcenter.example.com",
+		Topology: resolvers.VSphereFailureDomainTopology{
+			Datacenter:     "dc1",
+			ComputeCluster: "/dc1/host/cluster1",
+			Networks:       []string{"VM Network"},
+			Datastore:      "/dc1/datastore/ds1",
+			ResourcePool:   "/dc1/host/cluster1/Resources",
+		},
+	}}, failureDomains)
+}
+
+func TestSetVSphereFailureDomain(t *testing.T) {
+	assert := assert.New(t)
+
+	cluster := &ClusterInfo{
+		Infrastructure: configapi.InfrastructureStatus{InfrastructureName: "mycluster-jfnx7"},
+		VSphereFailureDomains: []resolvers.VSphereFailureDomain{{
+			Name:   "us-east-1",
+			Server: "vcenter.example.com",
+			Topology: resolvers.VSphereFailureDomainTopology{
+				Datacenter:   "dc1",
+				Networks:     []string{"VM Network"},
+				Datastore:    "/dc1/datastore/ds1",
+				ResourcePool: "/dc1/host/cluster1/Resources",
+			},
+		}},
+	}
+
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	unstructured.SetNestedField(machineSet.Object, int64(4), "spec", "template", "spec", "providerSpec", "value", "numCPUs")
+	assert.False(SetVSphereFailureDomain(logger, machineSet, ExtractObjectSections(machineSet), cluster))
+
+	machineSet.SetAnnotations(map[string]string{AnnotationFailureDomain: "unknown"})
+	assert.False(SetVSphereFailureDomain(logger, machineSet, ExtractObjectSections(machineSet), cluster))
+
+	machineSet.SetAnnotations(map[string]string{AnnotationFailureDomain: "us-east-1"})
+	section := ExtractObjectSections(machineSet)
+	assert.True(SetVSphereFailureDomain(logger, machineSet, section, cluster))
+	value, _, _ := unstructured.NestedMap(section.Object, "spec", "template", "spec", "providerSpec", "value")
+	assert.Equal(map[string]interface{}{
+		"numCPUs": int64(4),
+		"workspace": map[string]interface{}{
+			"server":       "vcenter.example.com",
+			"datacenter":   "dc1",
+			"datastore":    "/dc1/datastore/ds1",
+			"folder":       "/dc1/vm/mycluster-jfnx7",
+			"resourcePool": "/dc1/host/cluster1/Resources",
+		},
+		"network": map[string]interface{}{
+			"devices": []interface{}{map[string]interface{}{"networkName": "VM Network"}},
+		},
+		"template": "mycluster-jfnx7-rhcos",
+	}, value)
+
+	// The failure domain is applied to the providerSpec of a Machine, too
+	machine := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machine.SetKind(KindMachine)
+	machine.SetAnnotations(map[string]string{AnnotationFailureDomain: "us-east-1"})
+	unstructured.SetNestedField(machine.Object, int64(4), "spec", "providerSpec", "value", "numCPUs")
+	section = ExtractObjectSections(machine)
+	assert.True(SetVSphereFailureDomain(logger, machine, section, cluster))
+	template, _, _ := unstructured.NestedString(section.Object, "spec", "providerSpec", "value", "template")
+	assert.Equal("mycluster-jfnx7-rhcos", template)
+}
@@ -0,0 +1,75 @@
+package common
+
+import (
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+var jsonPointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// Fields holding maps with arbitrary keys, their entries are written as keys in field paths
+var mapFields = map[string]bool{
+	FieldLabels:      true,
+	FieldAnnotations: true,
+	FieldMatchLabels: true,
+}
+
+// Append a reference token to a JSON pointer (RFC 6901).
+func JoinJSONPointer(pointer, token string) string {
+	return pointer + "/" + jsonPointerEscaper.Replace(token)
+}
+
+// Convert a JSON pointer to a field path suitable for field-level error messages, for example
+// /spec/template/metadata/labels/app becomes spec.template.metadata.labels[app].
+func JSONPointerToFieldPath(pointer string) *field.Path {
+	var fldPath *field.Path
+	parent := ""
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = jsonPointerUnescaper.Replace(token)
+		switch {
+		case fldPath == nil:
+			fldPath = field.NewPath(token)
+		case mapFields[parent]:
+			fldPath = fldPath.Key(token)
+		default:
+			if index, err := strconv.Atoi(token); err == nil {
+				fldPath = fldPath.Index(index)
+			} else {
+				fldPath = fldPath.Child(token)
+			}
+		}
+		parent = token
+	}
+	return fldPath
+}
+
+// Walk the unstructured tree and replace each string leaf with the value returned by fn. Map keys,
+// numbers and booleans are never passed to fn. The path to the leaf is passed to fn as a JSON pointer.
+// The tree is modified in place, the (possibly replaced) root node is returned.
+func TransformStringLeaves(node interface{}, path string, fn func(path, value string) (string, error)) (interface{}, error) {
+	switch typedNode := node.(type) {
+	case string:
+		return fn(path, typedNode)
+	case map[string]interface{}:
+		for key, value := range typedNode {
+			newValue, err := TransformStringLeaves(value, JoinJSONPointer(path, key), fn)
+			if err != nil {
+				return nil, err
+			}
+			typedNode[key] = newValue
+		}
+	case []interface{}:
+		for index, value := range typedNode {
+			newValue, err := TransformStringLeaves(value, path+"/"+strconv.Itoa(index), fn)
+			if err != nil {
+				return nil, err
+			}
+			typedNode[index] = newValue
+		}
+	}
+	return node, nil
+}
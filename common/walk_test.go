@@ -0,0 +1,50 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJoinJSONPointer(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("/metadata", JoinJSONPointer("", "metadata"))
+	assert.Equal("/labels/machine.openshift.io~1cluster-api-cluster", JoinJSONPointer("/labels", "machine.openshift.io/cluster-api-cluster"))
+	assert.Equal("/a~0b", JoinJSONPointer("", "a~b"))
+}
+
+func TestTransformStringLeaves(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := map[string]interface{}{
+		"INFRANAME": "INFRANAME",
+		"count":     int64(3),
+		"enabled":   true,
+		"list":      []interface{}{"a-INFRANAME", map[string]interface{}{"b/c": "INFRANAME"}},
+	}
+	paths := []string{}
+
+	_, err := TransformStringLeaves(tree, "", func(path, value string) (string, error) {
+		paths = append(paths, path)
+		return strings.ReplaceAll(value, "INFRANAME", "mycluster"), nil
+	})
+	assert.Equal(nil, err)
+	assert.Equal(map[string]interface{}{
+		"INFRANAME": "mycluster",
+		"count":     int64(3),
+		"enabled":   true,
+		"list":      []interface{}{"a-mycluster", map[string]interface{}{"b/c": "mycluster"}},
+	}, tree)
+	assert.ElementsMatch([]string{"/INFRANAME", "/list/0", "/list/1/b~1c"}, paths)
+}
+
+func TestJSONPointerToFieldPath(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("spec.template.metadata.labels[machine.openshift.io/cluster-api-cluster]",
+		JSONPointerToFieldPath("/spec/template/metadata/labels/machine.openshift.io~1cluster-api-cluster").String())
+	assert.Equal("spec.template.spec.providerSpec.value.securityGroups[0].filters[1].name",
+		JSONPointerToFieldPath("/spec/template/spec/providerSpec/value/securityGroups/0/filters/1/name").String())
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Warn if the enabled annotation is present but its value doesn't enable the token replacement.
+func EnabledAnnotationWarning(obj *unstructured.Unstructured) string {
+	value, found := obj.GetAnnotations()[AnnotationEnabled]
+	if !found || value == "true" {
+		return ""
+	}
+	return "annotation \"" + AnnotationEnabled + "\" has value \"" + value + "\", only \"true\" enables the token replacement"
+}
+
+// Collect the warnings about suspicious use of the tokens in the object: the custom token name never
+// appears in the object, or the tokens appear in fields where they are never replaced.
+func TokenWarnings(obj *unstructured.Unstructured, tokenName string, tokens map[string]string) []string {
+	warnings := []string{}
+	substitution := NewSubstitution(logr.Discard(), obj, tokens)
+	section := ExtractObjectSections(obj)
+
+	// The token name annotation is set but neither the token nor its value appear in the object
+	if _, found := obj.GetAnnotations()[AnnotationTokenName]; found {
+		tokenOnly := NewSubstitution(logr.Discard(), obj, map[string]string{tokenName: tokens[tokenName]})
+		if len(tokenOnly.FindTokens(section.UnstructuredContent())) == 0 && !containsValue(section, substitution.Filter, tokens[tokenName]) {
+			warnings = append(warnings, "token \""+tokenName+"\" set in annotation \""+AnnotationTokenName+"\" doesn't appear in the object")
+		}
+	}
+
+	// Tokens in the object name are never replaced
+	for _, field := range []string{FieldName, "generateName"} {
+		if value, _, _ := unstructured.NestedString(obj.UnstructuredContent(), FieldMetadata, field); substitution.contains(value) {
+			warnings = append(warnings, "tokens in metadata."+field+" are not replaced")
+		}
+	}
+
+	// Tokens in map keys and in the operator's own annotations are never replaced
+	walkMapKeys(section.UnstructuredContent(), "", func(path, key string) {
+		if substitution.contains(key) {
+			warnings = append(warnings, "tokens in map keys are not replaced: "+JSONPointerToFieldPath(JoinJSONPointer(path, key)).String())
+		}
+	})
+	TransformStringLeaves(section.UnstructuredContent(), "", func(path, value string) (string, error) {
+		if isControlAnnotationPath(path) && !namesTokens(path) && substitution.contains(value) {
+			warnings = append(warnings, "tokens in the operator's annotations are not replaced: "+JSONPointerToFieldPath(path).String())
+		}
+		return value, nil
+	})
+
+	return warnings
+}
+
+// The annotations naming the tokens are expected to contain them
+func namesTokens(path string) bool {
+	return strings.HasSuffix(path, JoinJSONPointer("", AnnotationTokenName)) ||
+		strings.HasSuffix(path, JoinJSONPointer("", AnnotationTokens))
+}
+
+// Check if the value appears in any of the string leaves eligible for substitution.
+func containsValue(section *unstructured.Unstructured, filter *PathFilter, value string) bool {
+	if value == "" {
+		return false
+	}
+	found := false
+	TransformStringLeaves(section.UnstructuredContent(), "", func(path, leaf string) (string, error) {
+		found = found || (filter.Allows(path) && strings.Contains(leaf, value))
+		return leaf, nil
+	})
+	return found
+}
+
+// Walk the unstructured tree and call fn for each map key. The path to the map is passed to fn as a JSON pointer.
+func walkMapKeys(node interface{}, path string, fn func(path, key string)) {
+	switch typedNode := node.(type) {
+	case map[string]interface{}:
+		for key, value := range typedNode {
+			fn(path, key)
+			walkMapKeys(value, JoinJSONPointer(path, key), fn)
+		}
+	case []interface{}:
+		for index, value := range typedNode {
+			walkMapKeys(value, JoinJSONPointer(path, strconv.Itoa(index)), fn)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestEnabledAnnotationWarning(t *testing.T) {
+	assert := assert.New(t)
+
+	input := &unstructured.Unstructured{}
+	assert.Equal("", EnabledAnnotationWarning(input))
+
+	input.SetAnnotations(map[string]string{AnnotationEnabled: "true"})
+	assert.Equal("", EnabledAnnotationWarning(input))
+
+	input.SetAnnotations(map[string]string{AnnotationEnabled: "yes"})
+	assert.Contains(EnabledAnnotationWarning(input), "\"yes\"")
+}
+
+func TestTokenWarnings(t *testing.T) {
+	assert := assert.New(t)
+
+	tokens := map[string]string{"MYTOKEN": "mycluster-jfnx7"}
+
+	// Custom token name is used
+	input := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	input.SetAnnotations(map[string]string{AnnotationTokenName: "MYTOKEN"})
+	input.SetLabels(map[string]string{"machine.openshift.io/cluster-api-cluster": "MYTOKEN"})
+	assert.Empty(TokenWarnings(input, "MYTOKEN", tokens))
+
+	// Custom token name has already been replaced
+	input.SetLabels(map[string]string{"machine.openshift.io/cluster-api-cluster": "mycluster-jfnx7"})
+	assert.Empty(TokenWarnings(input, "MYTOKEN", tokens))
+
+	// Custom token name never appears
+	input.SetLabels(map[string]string{"machine.openshift.io/cluster-api-cluster": "INFRANAME"})
+	assert.Equal([]string{
+		"token \"MYTOKEN\" set in annotation \"" + AnnotationTokenName + "\" doesn't appear in the object",
+	}, TokenWarnings(input, "MYTOKEN", tokens))
+
+	// Tokens in unsupported fields
+	input = &unstructured.Unstructured{Object: map[string]interface{}{}}
+	input.SetName("MYTOKEN-worker")
+	input.SetLabels(map[string]string{"MYTOKEN/role": "worker"})
+	input.SetAnnotations(map[string]string{AnnotationInheritFrom: "MYTOKEN-worker-us-east-2a"})
+	assert.ElementsMatch([]string{
+		"tokens in metadata.name are not replaced",
+		"tokens in map keys are not replaced: metadata.labels[MYTOKEN/role]",
+		"tokens in the operator's annotations are not replaced: metadata.annotations[" + AnnotationInheritFrom + "]",
+	}, TokenWarnings(input, "MYTOKEN", tokens))
+}
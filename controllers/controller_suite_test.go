@@ -22,8 +22,10 @@ import (
 	"testing"
 	"time"
 
+	comm "github.com/noseka1/gitops-friendly-machinesets-operator/common"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	configapi "github.com/openshift/api/config/v1"
 	machineapi "github.com/openshift/api/machine/v1beta1"
 	"go.uber.org/zap/zapcore"
 	v1 "k8s.io/api/core/v1"
@@ -127,12 +129,18 @@ var _ = BeforeSuite(func() {
 	Expect(err).ToNot(HaveOccurred())
 
 	controllerName := "gitops-friendly-machinesets"
+	clusterInfo := &comm.ClusterInfo{
+		Infrastructure: configapi.InfrastructureStatus{InfrastructureName: "cluster-test-xyz"},
+	}
+	resolvers := comm.NewDefaultTokenResolverRegistry()
 
 	err = (&MachineSetReconciler{
-		Client:             mgr.GetClient(),
-		Scheme:             mgr.GetScheme(),
-		EventRecorder:      mgr.GetEventRecorderFor(controllerName),
-		InfrastructureName: "cluster-test-xyz",
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		EventRecorder: mgr.GetEventRecorderFor(controllerName),
+		ClusterInfo:   clusterInfo,
+		Resolvers:     resolvers,
+		APIReader:     mgr.GetAPIReader(),
 	}).SetupWithManager(mgr)
 	Expect(err).ToNot(HaveOccurred())
 
@@ -140,6 +148,8 @@ var _ = BeforeSuite(func() {
 		Client:        mgr.GetClient(),
 		Scheme:        mgr.GetScheme(),
 		EventRecorder: mgr.GetEventRecorderFor(controllerName),
+		ClusterInfo:   clusterInfo,
+		Resolvers:     resolvers,
 	},
 		func(mr *machineReconciler) {
 			mr.DeleteMachineMinAgeSeconds = -1
@@ -17,13 +17,14 @@ limitations under the License.
 package controllers
 
 import (
-	"bytes"
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
 	comm "github.com/noseka1/gitops-friendly-machinesets-operator/common"
+	"github.com/noseka1/gitops-friendly-machinesets-operator/resolvers"
 	machineapi "github.com/openshift/api/machine/v1beta1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -31,9 +32,14 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 // MachineReconciler reconciles a Machine object
@@ -41,14 +47,24 @@ type machineReconciler struct {
 	client.Client
 	Scheme                     *runtime.Scheme
 	EventRecorder              record.EventRecorder
+	ClusterInfo                *comm.ClusterInfo
+	Resolvers                  *resolvers.TokenResolverRegistry
 	DeleteMachineMinAgeSeconds int
 	DeleteMachineRequeueAfter  time.Duration
+	DrainAwareDeletion         bool
+	// Uncached reader used to read Nodes, pods and PodDisruptionBudgets
+	APIReader client.Reader
 }
 
 type MachineReconcilerConfig struct {
 	client.Client
 	Scheme        *runtime.Scheme
 	EventRecorder record.EventRecorder
+	ClusterInfo   *comm.ClusterInfo
+	Resolvers     *resolvers.TokenResolverRegistry
+	// Cordon the Node and check the PodDisruptionBudgets before deleting a Machine
+	DrainAwareDeletion bool
+	APIReader          client.Reader
 }
 
 func NewMachineReconciler(config MachineReconcilerConfig, options ...func(*machineReconciler)) *machineReconciler {
@@ -56,8 +72,12 @@ func NewMachineReconciler(config MachineReconcilerConfig, options ...func(*machi
 		Client:                     config.Client,
 		Scheme:                     config.Scheme,
 		EventRecorder:              config.EventRecorder,
+		ClusterInfo:                config.ClusterInfo,
+		Resolvers:                  config.Resolvers,
 		DeleteMachineMinAgeSeconds: 60,
 		DeleteMachineRequeueAfter:  20 * time.Second,
+		DrainAwareDeletion:         config.DrainAwareDeletion,
+		APIReader:                  config.APIReader,
 	}
 	for _, option := range options {
 		option(reconciler)
@@ -65,16 +85,87 @@ func NewMachineReconciler(config MachineReconcilerConfig, options ...func(*machi
 	return reconciler
 }
 
+// Index of the Machines by the name of the MachineSet that controls them
+const machineOwnerIndex = ".metadata.ownerReferences.machineSet"
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *machineReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(context.TODO(), &machineapi.Machine{}, machineOwnerIndex, indexMachineOwner)
+	if err != nil {
+		return err
+	}
+
+	// Status updates of the MachineSet don't change the settings inherited by its Machines. Only the change
+	// of the observed generation matters, the Machines waiting for machine-api to observe the patched
+	// MachineSet can be deleted then
+	machineSetPredicates := predicate.Or(
+		predicate.GenerationChangedPredicate{},
+		predicate.AnnotationChangedPredicate{},
+		observedGenerationChangedPredicate())
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&machineapi.Machine{}).
+		Watches(&source.Kind{Type: &machineapi.MachineSet{}},
+			handler.EnqueueRequestsFromMapFunc(r.machinesForMachineSet),
+			builder.WithPredicates(machineSetPredicates)).
 		Complete(r)
 }
 
+// Name of the MachineSet that controls the Machine.
+func indexMachineOwner(obj client.Object) []string {
+	ownerRef := v1.GetControllerOf(obj)
+	if ownerRef == nil || ownerRef.Kind != comm.KindMachineSet {
+		return nil
+	}
+	return []string{ownerRef.Name}
+}
+
+// Pass the updates that change the status.observedGeneration of the MachineSet.
+func observedGenerationChangedPredicate() predicate.Funcs {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldMachineSet, ok := e.ObjectOld.(*machineapi.MachineSet)
+			if !ok {
+				return false
+			}
+			newMachineSet, ok := e.ObjectNew.(*machineapi.MachineSet)
+			if !ok {
+				return false
+			}
+			return oldMachineSet.Status.ObservedGeneration != newMachineSet.Status.ObservedGeneration
+		},
+	}
+}
+
+// When a MachineSet changes, reconcile all Machines that it owns. The Machines inherit its settings.
+func (r *machineReconciler) machinesForMachineSet(obj client.Object) []reconcile.Request {
+	ctx := context.TODO()
+	logger := log.FromContext(ctx).WithValues(comm.FieldNamespace, obj.GetNamespace(), comm.FieldName, obj.GetName())
+
+	machines := &machineapi.MachineList{}
+	err := r.List(ctx, machines, client.InNamespace(obj.GetNamespace()), client.MatchingFields{machineOwnerIndex: obj.GetName()})
+	if err != nil {
+		logger.Error(err, "Failed to list Machines owned by MachineSet.")
+		return nil
+	}
+
+	// A MachineSet re-created under the same name doesn't own the Machines
+	requests := []reconcile.Request{}
+	for _, machine := range machines.Items {
+		ownerRef := v1.GetControllerOf(&machine)
+		if ownerRef != nil && ownerRef.UID == obj.GetUID() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&machine)})
+		}
+	}
+	return requests
+}
+
 //+kubebuilder:rbac:groups=machine.openshift.io,resources=machines,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=machine.openshift.io,resources=machines/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=machine.openshift.io,resources=machines/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;patch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=list
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=list
 func (r *machineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
 
@@ -93,47 +184,96 @@ func (r *machineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return reconcile.Result{}, nil
 	}
 
+	// The Machine inherits the settings of the MachineSet that owns it
+	owner, err := comm.GetOwnerMachineSet(ctx, logger, r.Client, machine)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	settings := comm.InheritControlAnnotations(machine, owner)
+
 	// Is this object enabled for reconciliation?
-	enabled, tokenName := comm.EvaluateAnnotations(logger, machine)
+	enabled, tokenName := comm.EvaluateAnnotations(logger, settings)
 	if !enabled {
 		return reconcile.Result{}, nil
 	}
 
-	// Extract Machine sections that should have been patched
-	machineBytes, err := comm.MarshalObjectSections(logger, machine)
-	if err != nil {
-		return reconcile.Result{}, nil
+	// Extract Machine sections that should have been patched. The labels matched by the selector of the
+	// owner MachineSet are never patched
+	machineSection := comm.ExtractObjectSections(machine)
+	labels := machineSection.GetLabels()
+	for _, key := range comm.SelectorLabelKeys(owner) {
+		delete(labels, key)
 	}
+	machineSection.SetLabels(labels)
 
-	// If we cannot find the token in the Machine object, we are going to leave this object alone
-	if !bytes.Contains(machineBytes, []byte(tokenName)) {
+	// If we cannot find any of the tokens or templates in the Machine object, we are going to leave this object alone
+	tokens, err := comm.ResolveTokens(ctx, logger, r.Client, settings, tokenName, r.ClusterInfo, r.Resolvers)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	substitution := comm.NewSubstitution(logger, settings, tokens)
+	if !substitution.ContainsTokens(machineSection.UnstructuredContent()) &&
+		!(comm.IsTemplateRenderingEnabled(settings) && comm.ContainsTemplates(machineSection.UnstructuredContent(), substitution.Filter)) {
 		return reconcile.Result{}, nil
 	}
 
 	// Machine object contains tokens that were not replaced. Will delete this Machine eventually
-	if r.deleteMachineNow(logger, machine) {
-		// Delete the Machine object in Kubernetes.
-		err = r.Delete(ctx, machine, &client.DeleteOptions{})
+	if owner != nil {
+		// The Machine is reconciled again when the owner MachineSet changes
+		if !isPatchedTemplateObserved(logger, owner, tokens) {
+			return ctrl.Result{}, nil
+		}
+	} else if !r.deleteMachineNow(logger, machine) {
+		// Requeue the request
+		return ctrl.Result{RequeueAfter: r.DeleteMachineRequeueAfter}, nil
+	}
+
+	// Make sure the workloads running on the Machine can be evicted
+	if r.DrainAwareDeletion {
+		ready, err := r.prepareNodeForDeletion(ctx, logger, machine)
 		if err != nil {
-			err = processKubernetesError(logger, "delete", err)
 			return reconcile.Result{}, err
 		}
+		if !ready {
+			return ctrl.Result{RequeueAfter: r.DeleteMachineRequeueAfter}, nil
+		}
+	}
 
-		msg := "Machine contains unresolved tokens \"" + tokenName + "\". Deleting it."
-		r.EventRecorder.Event(machine, comm.EventTypeNormal, comm.EventReasonDelete, msg)
-		logger.Info(msg)
-		return ctrl.Result{}, nil
+	// Delete the Machine object in Kubernetes.
+	err = r.Delete(ctx, machine, &client.DeleteOptions{})
+	if err != nil {
+		err = processKubernetesError(logger, "delete", err)
+		return reconcile.Result{}, err
 	}
 
-	// Requeue the request
-	return ctrl.Result{RequeueAfter: r.DeleteMachineRequeueAfter}, nil
+	msg := "Machine contains unresolved tokens \"" + strings.Join(comm.TokenNames(tokens), ", ") + "\". Deleting it."
+	r.EventRecorder.Event(machine, comm.EventTypeNormal, comm.EventReasonDelete, msg)
+	logger.Info(msg)
+	return ctrl.Result{}, nil
+}
+
+// Check if the Machine owned by the MachineSet can be deleted. After we delete the Machine, machine-api
+// immediately creates a replacement. The tokens in the MachineSet must have been replaced and machine-api
+// must have observed the patched MachineSet, otherwise the replacement would contain the tokens again.
+func isPatchedTemplateObserved(logger logr.Logger, machineSet *unstructured.Unstructured, tokens map[string]string) bool {
+	machineSetSection := comm.ExtractObjectSections(machineSet).UnstructuredContent()
+	substitution := comm.NewSubstitution(logger, machineSet, tokens)
+	if substitution.ContainsTokens(machineSetSection) ||
+		(comm.IsTemplateRenderingEnabled(machineSet) && comm.ContainsTemplates(machineSetSection, substitution.Filter)) {
+		logger.V(3).Info("Not deleting machine, tokens in the owner MachineSet haven't been replaced yet.")
+		return false
+	}
+	if !comm.IsPatchedGenerationObserved(machineSet) {
+		logger.V(3).Info("Not deleting machine, machine-api hasn't observed the patched MachineSet yet.")
+		return false
+	}
+	return true
 }
 
-// Check if we should send the delete request at this time. If the Machine was created based on the MachineSet
-// that our controller haven't updated on time, we want to delay the deletion of this Machine.
-// We want to give machine-api-controller enough time to notice the MachineSet update. After we delete the Machine,
-// a new Machine will immediately be created. Leave enough time so that the replacement Machine is created based
-// on the udpated MachineSet.
+// Check if we should send the delete request at this time. Used for Machines without an owner MachineSet,
+// we cannot tell which MachineSet generation such a Machine was created from. If the Machine was created
+// based on a MachineSet that our controller haven't updated on time, we want to delay the deletion of this
+// Machine. We want to give machine-api-controller enough time to notice the MachineSet update.
 func (r *machineReconciler) deleteMachineNow(logger logr.Logger, machine *unstructured.Unstructured) bool {
 	now := v1.NewTime(time.Now())
 	creationTime := machine.GetCreationTimestamp().Time
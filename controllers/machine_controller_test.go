@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	comm "github.com/noseka1/gitops-friendly-machinesets-operator/common"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/assert"
@@ -11,6 +12,7 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 
 	machineapi "github.com/openshift/api/machine/v1beta1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -35,6 +37,61 @@ func TestDeleteMachineNow(t *testing.T) {
 	assert.Equal(true, mr.deleteMachineNow(logger, machine))
 }
 
+func TestIndexMachineOwner(t *testing.T) {
+	assert := assert.New(t)
+
+	controller := true
+	machine := &machineapi.Machine{}
+	assert.Empty(indexMachineOwner(machine))
+
+	machine.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: "machine.openshift.io/v1beta1",
+		Kind:       "MachineSet",
+		Name:       "worker",
+		UID:        "1234",
+		Controller: &controller,
+	}})
+	assert.Equal([]string{"worker"}, indexMachineOwner(machine))
+}
+
+func TestObservedGenerationChangedPredicate(t *testing.T) {
+	assert := assert.New(t)
+
+	oldMachineSet := &machineapi.MachineSet{}
+	oldMachineSet.Status.ObservedGeneration = 1
+	newMachineSet := oldMachineSet.DeepCopy()
+	newMachineSet.Status.Replicas = 2
+	p := observedGenerationChangedPredicate()
+
+	// Other status updates are filtered out
+	assert.False(p.Update(event.UpdateEvent{ObjectOld: oldMachineSet, ObjectNew: newMachineSet}))
+
+	newMachineSet.Status.ObservedGeneration = 2
+	assert.True(p.Update(event.UpdateEvent{ObjectOld: oldMachineSet, ObjectNew: newMachineSet}))
+}
+
+func TestIsPatchedTemplateObserved(t *testing.T) {
+	assert := assert.New(t)
+
+	tokens := map[string]string{"INFRANAME": "cluster-test-xyz"}
+	machineSet := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	machineSet.SetGeneration(2)
+	machineSet.SetAnnotations(map[string]string{comm.AnnotationEnabled: "true"})
+	unstructured.SetNestedField(machineSet.Object, int64(2), "status", "observedGeneration")
+
+	// The tokens in the MachineSet haven't been replaced yet
+	unstructured.SetNestedField(machineSet.Object, "INFRANAME-worker", "spec", "template", "metadata", "labels", "name")
+	assert.False(isPatchedTemplateObserved(logger, machineSet, tokens))
+
+	// machine-api observed the patched MachineSet
+	unstructured.SetNestedField(machineSet.Object, "cluster-test-xyz-worker", "spec", "template", "metadata", "labels", "name")
+	assert.True(isPatchedTemplateObserved(logger, machineSet, tokens))
+
+	// machine-api hasn't observed the generation at which the tokens were replaced
+	machineSet.SetAnnotations(map[string]string{comm.AnnotationEnabled: "true", comm.AnnotationPatchedGeneration: "3"})
+	assert.False(isPatchedTemplateObserved(logger, machineSet, tokens))
+}
+
 var _ = Describe("Machine controller", func() {
 
 	Context("When Machine has unresolved tokens", func() {
@@ -143,4 +200,61 @@ var _ = Describe("Machine controller", func() {
 			}).ShouldNot(HaveOccurred())
 		})
 	})
+
+	Context("When Machine has unresolved tokens and its owner MachineSet is enabled", func() {
+		It("Should delete the Machine", func() {
+			By("Creating an enabled MachineSet")
+			machineSet := &machineapi.MachineSet{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: "machine.openshift.io/v1beta1",
+					Kind:       "MachineSet",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "machineset-owner",
+					Namespace: "openshift-machine-api",
+					Annotations: map[string]string{
+						"gitops-friendly-machinesets.redhat-cop.io/enabled": "true"},
+				},
+			}
+			err := k8sClient.Create(ctx, machineSet, &client.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			By("Marking the MachineSet as observed by machine-api")
+			machineSet.Status.ObservedGeneration = machineSet.GetGeneration()
+			err = k8sClient.Status().Update(ctx, machineSet)
+			Expect(err).ToNot(HaveOccurred())
+			By("Defining a Machine owned by the MachineSet with unresolved tokens")
+			controller := true
+			machine := &machineapi.Machine{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: "machine.openshift.io/v1beta1",
+					Kind:       "Machine",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "machine4",
+					Namespace: "openshift-machine-api",
+					Labels: map[string]string{
+						"machine.openshift.io/cluster-api-cluster": "INFRANAME",
+					},
+					OwnerReferences: []metav1.OwnerReference{{
+						APIVersion: "machine.openshift.io/v1beta1",
+						Kind:       "MachineSet",
+						Name:       machineSet.GetName(),
+						UID:        machineSet.GetUID(),
+						Controller: &controller,
+					}},
+				},
+				Spec: machineapi.MachineSpec{},
+			}
+			By("Creating the Machine in Kubernetes")
+			err = k8sClient.Create(ctx, machine, &client.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			By("Waiting until the Machine has been deleted")
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx,
+					types.NamespacedName{Namespace: machine.GetNamespace(), Name: machine.GetName()},
+					machine)
+				return apierrors.IsNotFound(err)
+			}).Should(BeTrue())
+		})
+	})
 })
@@ -0,0 +1,173 @@
+/*
+Copyright 2021 Ales Nosek.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	comm "github.com/noseka1/gitops-friendly-machinesets-operator/common"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// Annotation found on the static pods mirrored to the API server, they cannot be evicted
+	mirrorPodAnnotation = "kubernetes.io/config.mirror"
+	nodeNameField       = "spec.nodeName"
+)
+
+// Prepare the Node of the Machine for the deletion. The Node is cordoned, so that no new pods are scheduled
+// on it. Returns true if the pods running on the Node can be evicted without violating their
+// PodDisruptionBudgets. machine-api drains the Node when the Machine is deleted.
+func (r *machineReconciler) prepareNodeForDeletion(ctx context.Context, logger logr.Logger, machine *unstructured.Unstructured) (bool, error) {
+	nodeName, _, _ := unstructured.NestedString(machine.UnstructuredContent(), comm.FieldStatus, comm.FieldNodeRef, comm.FieldName)
+	if nodeName == "" {
+		logger.V(1).Info("Machine has no Node, nothing to drain.")
+		return true, nil
+	}
+
+	// Fetch the Node object from Kubernetes
+	node := &corev1.Node{}
+	err := r.APIReader.Get(ctx, client.ObjectKey{Name: nodeName}, node)
+	if apierrors.IsNotFound(err) {
+		logger.V(1).Info("Node \"" + nodeName + "\" of the Machine not found, nothing to drain.")
+		return true, nil
+	} else if err != nil {
+		logger.Error(err, "Failed to retrieve Node \""+nodeName+"\".")
+		return false, err
+	}
+
+	// Cordon the Node
+	if !node.Spec.Unschedulable {
+		original := node.DeepCopy()
+		node.Spec.Unschedulable = true
+		err = r.Patch(ctx, node, client.MergeFrom(original), &client.PatchOptions{})
+		if err != nil {
+			msg := "Failed to cordon Node \"" + nodeName + "\"."
+			r.EventRecorder.Event(machine, comm.EventTypeWarning, comm.EventReasonCordon, msg)
+			logger.Error(err, msg)
+			return false, err
+		}
+		msg := "Cordoned Node \"" + nodeName + "\" before deleting the Machine."
+		r.EventRecorder.Event(machine, comm.EventTypeNormal, comm.EventReasonCordon, msg)
+		logger.Info(msg)
+	}
+
+	// Check that the pods can be evicted
+	blocked, err := r.findBlockingDisruptionBudgets(ctx, logger, nodeName)
+	if err != nil {
+		return false, err
+	}
+	if len(blocked) > 0 {
+		for _, msg := range blocked {
+			r.EventRecorder.Event(machine, comm.EventTypeWarning, comm.EventReasonDisruptionBudget, msg+" Not deleting the Machine yet.")
+			logger.Info(msg + " Not deleting the Machine yet.")
+		}
+		msg := "Node \"" + nodeName + "\" stays cordoned until the PodDisruptionBudgets allow evicting its pods."
+		r.EventRecorder.Event(machine, comm.EventTypeWarning, comm.EventReasonCordon, msg)
+		logger.Info(msg)
+		return false, nil
+	}
+
+	msg := "PodDisruptionBudgets allow evicting all pods from Node \"" + nodeName + "\"."
+	r.EventRecorder.Event(machine, comm.EventTypeNormal, comm.EventReasonDisruptionBudget, msg)
+	logger.Info(msg)
+	return true, nil
+}
+
+// Find the PodDisruptionBudgets that don't allow evicting the pods running on the Node. The Node is drained
+// at once, so a PodDisruptionBudget blocks the deletion if it covers more pods on the Node than the number
+// of disruptions it allows. Returns a message for each blocking PodDisruptionBudget.
+func (r *machineReconciler) findBlockingDisruptionBudgets(ctx context.Context, logger logr.Logger, nodeName string) ([]string, error) {
+	pods := &corev1.PodList{}
+	err := r.APIReader.List(ctx, pods, client.MatchingFields{nodeNameField: nodeName})
+	if err != nil {
+		logger.Error(err, "Failed to list pods running on Node \""+nodeName+"\".")
+		return nil, err
+	}
+
+	budgets := map[string][]policyv1.PodDisruptionBudget{}
+	coveredBudgets := map[string]*policyv1.PodDisruptionBudget{}
+	coveredPods := map[string]int32{}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != nodeName || !isEvictionRequired(&pod) {
+			continue
+		}
+
+		// Fetch the PodDisruptionBudgets from the pod's namespace
+		namespaceBudgets, found := budgets[pod.Namespace]
+		if !found {
+			budgetList := &policyv1.PodDisruptionBudgetList{}
+			err = r.APIReader.List(ctx, budgetList, &client.ListOptions{Namespace: pod.Namespace})
+			if err != nil {
+				logger.Error(err, "Failed to list PodDisruptionBudgets in namespace "+pod.Namespace)
+				return nil, err
+			}
+			namespaceBudgets = budgetList.Items
+			budgets[pod.Namespace] = namespaceBudgets
+		}
+
+		// Count the pods on the Node covered by each PodDisruptionBudget
+		for i := range namespaceBudgets {
+			budget := &namespaceBudgets[i]
+			selector, err := v1.LabelSelectorAsSelector(budget.Spec.Selector)
+			if err != nil || !selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			key := budget.Namespace + "/" + budget.Name
+			coveredBudgets[key] = budget
+			coveredPods[key]++
+		}
+	}
+
+	keys := make([]string, 0, len(coveredBudgets))
+	for key := range coveredBudgets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	blocked := []string{}
+	for _, key := range keys {
+		allowed := coveredBudgets[key].Status.DisruptionsAllowed
+		if coveredPods[key] > allowed {
+			blocked = append(blocked, fmt.Sprintf("PodDisruptionBudget \"%s\" allows %d disruptions, but covers %d pods on Node \"%s\".",
+				key, allowed, coveredPods[key], nodeName))
+		}
+	}
+	return blocked, nil
+}
+
+// Check if the pod is evicted when draining the Node. Terminated pods, mirror pods and pods managed
+// by a DaemonSet are left alone.
+func isEvictionRequired(pod *corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	if _, found := pod.Annotations[mirrorPodAnnotation]; found {
+		return false
+	}
+	ownerRef := v1.GetControllerOf(pod)
+	return ownerRef == nil || ownerRef.Kind != "DaemonSet"
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newDrainTestReconciler(objs ...client.Object) (*machineReconciler, *record.FakeRecorder) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	recorder := record.NewFakeRecorder(10)
+	return NewMachineReconciler(MachineReconcilerConfig{
+		Client:             fakeClient,
+		EventRecorder:      recorder,
+		DrainAwareDeletion: true,
+		APIReader:          fakeClient,
+	}), recorder
+}
+
+func newDrainTestMachine(nodeName string) *unstructured.Unstructured {
+	machine := newMachineUnstructured()
+	machine.SetName("mycluster-worker-us-east-2a-abcde")
+	machine.SetNamespace("openshift-machine-api")
+	if nodeName != "" {
+		unstructured.SetNestedField(machine.Object, nodeName, "status", "nodeRef", "name")
+	}
+	return machine
+}
+
+func TestPrepareNodeForDeletion(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "app", Labels: map[string]string{"app": "app"}},
+		Spec:       corev1.PodSpec{NodeName: node.Name},
+	}
+	budget := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "app"},
+		Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}}},
+		Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: 0},
+	}
+	mr, recorder := newDrainTestReconciler(node, pod, budget)
+
+	// The Node is cordoned, the PodDisruptionBudget doesn't allow the eviction
+	ready, err := mr.prepareNodeForDeletion(ctx, logger, newDrainTestMachine(node.Name))
+	assert.Nil(err)
+	assert.False(ready)
+	assert.Nil(mr.Get(ctx, client.ObjectKeyFromObject(node), node))
+	assert.True(node.Spec.Unschedulable)
+	assert.Contains(<-recorder.Events, "Cordoned Node \"worker-1\"")
+	assert.Contains(<-recorder.Events, "PodDisruptionBudget \"app/app\" allows 0 disruptions, but covers 1 pods")
+	assert.Contains(<-recorder.Events, "Node \"worker-1\" stays cordoned")
+
+	// The PodDisruptionBudget allows the eviction
+	budget.Status.DisruptionsAllowed = 1
+	assert.Nil(mr.Update(ctx, budget))
+	ready, err = mr.prepareNodeForDeletion(ctx, logger, newDrainTestMachine(node.Name))
+	assert.Nil(err)
+	assert.True(ready)
+	assert.Contains(<-recorder.Events, "allow evicting all pods from Node \"worker-1\"")
+
+	// Machines without a Node can be deleted right away
+	ready, err = mr.prepareNodeForDeletion(ctx, logger, newDrainTestMachine(""))
+	assert.Nil(err)
+	assert.True(ready)
+	ready, err = mr.prepareNodeForDeletion(ctx, logger, newDrainTestMachine("worker-2"))
+	assert.Nil(err)
+	assert.True(ready)
+}
+
+func TestFindBlockingDisruptionBudgets(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	newPod := func(name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "app", Labels: map[string]string{"app": "app"}},
+			Spec:       corev1.PodSpec{NodeName: "worker-1"},
+		}
+	}
+	budget := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "app"},
+		Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}}},
+		Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: 1},
+	}
+	mr, _ := newDrainTestReconciler(newPod("app-1"), newPod("app-2"), budget)
+
+	// Each pod could be evicted on its own, but not both of them
+	blocked, err := mr.findBlockingDisruptionBudgets(ctx, logger, "worker-1")
+	assert.Nil(err)
+	assert.Equal([]string{"PodDisruptionBudget \"app/app\" allows 1 disruptions, but covers 2 pods on Node \"worker-1\"."}, blocked)
+
+	budget.Status.DisruptionsAllowed = 2
+	assert.Nil(mr.Update(ctx, budget))
+	blocked, err = mr.findBlockingDisruptionBudgets(ctx, logger, "worker-1")
+	assert.Nil(err)
+	assert.Empty(blocked)
+}
+
+func TestIsEvictionRequired(t *testing.T) {
+	assert := assert.New(t)
+
+	controller := true
+	pod := &corev1.Pod{}
+	assert.True(isEvictionRequired(pod))
+
+	pod.Status.Phase = corev1.PodSucceeded
+	assert.False(isEvictionRequired(pod))
+
+	pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{mirrorPodAnnotation: "hash"}}}
+	assert.False(isEvictionRequired(pod))
+
+	pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{{
+		APIVersion: "apps/v1", Kind: "DaemonSet", Name: "node-exporter", Controller: &controller}}}}
+	assert.False(isEvictionRequired(pod))
+}
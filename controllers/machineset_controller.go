@@ -23,8 +23,10 @@ import (
 
 	"github.com/go-logr/logr"
 	comm "github.com/noseka1/gitops-friendly-machinesets-operator/common"
+	"github.com/noseka1/gitops-friendly-machinesets-operator/resolvers"
 	machineapi "github.com/openshift/api/machine/v1beta1"
 	jsonpatch "gomodules.xyz/jsonpatch/v2"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -32,16 +34,23 @@ import (
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 // MachineSetReconciler reconciles a MachineSet object
 type MachineSetReconciler struct {
 	client.Client
-	Scheme             *runtime.Scheme
-	EventRecorder      record.EventRecorder
-	InfrastructureName string
+	Scheme        *runtime.Scheme
+	EventRecorder record.EventRecorder
+	ClusterInfo   *comm.ClusterInfo
+	Resolvers     *resolvers.TokenResolverRegistry
+	// Uncached reader used to read objects from namespaces not cached by the manager
+	APIReader client.Reader
+	// CoreOS stream metadata used to resolve the boot images
+	streams comm.CoreOSStreamCache
 }
 
 //+kubebuilder:rbac:groups=machine.openshift.io,resources=machinesets,verbs=get;list;watch;create;update;patch;delete
@@ -71,15 +80,25 @@ func (r *MachineSetReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return reconcile.Result{}, nil
 	}
 
+	// The controller is the backstop for MachineSets that were admitted without going through the
+	// webhook, either because they are not labeled or because the webhook was unavailable
+	if !comm.IsWebhookLabelPresent(machineSet) {
+		logger.V(1).Info("MachineSet is missing the label \"" + comm.LabelEnabled + "\". The webhook might not process it, tokens are replaced by the controller.")
+	}
+
 	// Replace tokens in the MachineSet object
-	err = r.replaceTokens(ctx, req, machineSet, tokenName)
+	tokens, err := comm.ResolveTokens(ctx, logger, r.Client, machineSet, tokenName, r.ClusterInfo, r.Resolvers)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	err = r.replaceTokens(ctx, req, machineSet, tokens)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
 
 	// If the managed MachineSet has at least one node available, check and scale the
 	// installer-provisioned MachineSets to zero
-	if isWorkerMachineSet(machineSet) && hasNodesAvailable(machineSet) {
+	if comm.IsWorkerMachineSet(machineSet) && hasNodesAvailable(machineSet) {
 		err = r.scaleInstallerProvisionedMachineSetsToZero(ctx, req)
 		if err != nil {
 			return ctrl.Result{}, err
@@ -93,13 +112,37 @@ func (r *MachineSetReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 func (r *MachineSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&machineapi.MachineSet{}).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}},
+			handler.EnqueueRequestsFromMapFunc(r.machineSetsForTokenSource(comm.TokenSourceKindConfigMap))).
+		Watches(&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.machineSetsForTokenSource(comm.TokenSourceKindSecret))).
 		Complete(r)
 }
 
-func isWorkerMachineSet(machineSet *unstructured.Unstructured) bool {
-	role, _, _ := unstructured.NestedFieldNoCopy(machineSet.UnstructuredContent(), comm.FieldSpec, comm.FieldTemplate, comm.FieldMetadata, comm.FieldLabels, comm.LabelMachineRole)
-	roleString, ok := role.(string)
-	return ok && roleString == comm.MachineRoleWorker
+// When a ConfigMap or Secret changes, reconcile all MachineSets that read tokens from it.
+func (r *MachineSetReconciler) machineSetsForTokenSource(kind string) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		ctx := context.TODO()
+		tokenSource := comm.TokenSource{Kind: kind, Name: obj.GetName()}
+		logger := log.FromContext(ctx).WithValues("tokenSource", obj.GetNamespace()+"/"+tokenSource.String())
+
+		machineSets := comm.NewMachineSetUnstructuredList()
+		err := r.List(ctx, machineSets, &client.ListOptions{Namespace: obj.GetNamespace()})
+		if err != nil {
+			logger.Error(err, "Failed to retrieve MachineSets from namespace "+obj.GetNamespace())
+			return nil
+		}
+
+		requests := []reconcile.Request{}
+		for _, machineSet := range machineSets.Items {
+			if comm.IsObjectReconciliationEnabled(&machineSet) && comm.ReferencesTokenSource(logger, &machineSet, tokenSource) {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+					Namespace: machineSet.GetNamespace(),
+					Name:      machineSet.GetName()}})
+			}
+		}
+		return requests
+	}
 }
 
 func hasNodesAvailable(machineSet *unstructured.Unstructured) bool {
@@ -108,11 +151,6 @@ func hasNodesAvailable(machineSet *unstructured.Unstructured) bool {
 	return ok && availableReplicasInt > 0
 }
 
-func nameStartsWith(machineSet *unstructured.Unstructured, prefix string) bool {
-	name := machineSet.GetName()
-	return strings.HasPrefix(name, prefix)
-}
-
 func isReplicasGreaterThanZero(machineSet *unstructured.Unstructured) bool {
 	replicas, _, _ := unstructured.NestedFieldNoCopy(machineSet.UnstructuredContent(), comm.FieldSpec, comm.FieldReplicas)
 	replicasInt, ok := replicas.(int64)
@@ -124,18 +162,13 @@ func isReplicasGreaterThanZero(machineSet *unstructured.Unstructured) bool {
 func (r *MachineSetReconciler) scaleInstallerProvisionedMachineSetsToZero(ctx context.Context, req ctrl.Request) error {
 	logger := log.FromContext(ctx)
 
-	allMachineSetsInNamespace := newMachineSetUnstructuredList()
-	err := r.List(ctx, allMachineSetsInNamespace, &client.ListOptions{Namespace: comm.NamespaceOpenShiftMachineApi})
+	installerMachineSets, err := comm.ListInstallerMachineSets(ctx, logger, r, r.ClusterInfo.InfrastructureName())
 	if err != nil {
-		logger.Error(err, "Failed to retrieve MachineSets from namespace "+comm.NamespaceOpenShiftMachineApi)
 		return err
 	}
 
-	for _, machineSet := range allMachineSetsInNamespace.Items {
-		if isWorkerMachineSet(&machineSet) &&
-			nameStartsWith(&machineSet, r.InfrastructureName) &&
-			!comm.IsObjectReconciliationEnabled(&machineSet) &&
-			isReplicasGreaterThanZero(&machineSet) {
+	for _, machineSet := range installerMachineSets {
+		if isReplicasGreaterThanZero(&machineSet) {
 			newLogger := log.FromContext(ctx, "scaled machineset", machineSet.GetNamespace()+"/"+machineSet.GetName())
 			err := r.scaleMachineSetToZero(ctx, newLogger, &machineSet)
 			if err != nil {
@@ -169,15 +202,34 @@ func (r *MachineSetReconciler) scaleMachineSetToZero(ctx context.Context, logger
 	return nil
 }
 
-func (r *MachineSetReconciler) replaceTokens(ctx context.Context, req ctrl.Request, machineSet *unstructured.Unstructured, tokenName string) error {
+func (r *MachineSetReconciler) replaceTokens(ctx context.Context, req ctrl.Request, machineSet *unstructured.Unstructured, tokens map[string]string) error {
 	logger := log.FromContext(ctx)
 
+	section, err := comm.SubstituteTokens(logger, machineSet, tokens, r.ClusterInfo)
+	if err != nil {
+		return nil
+	}
+
+	// Keep the RHCOS boot image up to date
+	err = comm.ResolveBootImage(ctx, logger, &r.streams, r.APIReader, machineSet, section, r.ClusterInfo)
+	if err != nil {
+		return err
+	}
+
 	// Compute the JSON patch
-	machineSetPatchBytes, err := comm.CreatePatch(logger, machineSet, tokenName, r.InfrastructureName)
+	machineSetPatchBytes, err := comm.CreateSectionsPatch(logger, machineSet, section)
 	if err != nil || len(machineSetPatchBytes) == 0 {
 		return nil
 	}
 
+	// Record the generation of the patched MachineSet. The Machine controller waits until machine-api
+	// observes it before deleting the Machines that still contain tokens
+	comm.SetPatchedGeneration(machineSet, section)
+	machineSetPatchBytes, err = comm.CreateSectionsPatch(logger, machineSet, section)
+	if err != nil {
+		return nil
+	}
+
 	// Patch the MachineSet object in Kubernetes
 	err = r.Patch(ctx, machineSet, client.RawPatch(types.JSONPatchType, machineSetPatchBytes), &client.PatchOptions{})
 	if err != nil {
@@ -185,7 +237,7 @@ func (r *MachineSetReconciler) replaceTokens(ctx context.Context, req ctrl.Reque
 		return err
 	}
 
-	logger.Info("Tokens \"" + tokenName + "\" in MachineSet replaced successfully.")
+	logger.Info("Tokens \"" + strings.Join(comm.TokenNames(tokens), ", ") + "\" in MachineSet replaced successfully.")
 	return nil
 }
 
@@ -198,13 +250,3 @@ func newMachineSetUnstructured() *unstructured.Unstructured {
 	})
 	return machineSet
 }
-
-func newMachineSetUnstructuredList() *unstructured.UnstructuredList {
-	machineSetList := &unstructured.UnstructuredList{Object: map[string]interface{}{}}
-	machineSetList.SetGroupVersionKind(schema.GroupVersionKind{
-		Group:   machineapi.SchemeGroupVersion.Group,
-		Version: machineapi.SchemeGroupVersion.Version,
-		Kind:    "MachineSet",
-	})
-	return machineSetList
-}
@@ -3,30 +3,19 @@ package controllers
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	machineapi "github.com/openshift/api/machine/v1beta1"
 	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func TestIsWorkerMachineSet(t *testing.T) {
-	assert := assert.New(t)
-
-	var machineSet *unstructured.Unstructured
-
-	machineSet = &unstructured.Unstructured{}
-	assert.Equal(false, isWorkerMachineSet(machineSet))
-
-	machineSet = &unstructured.Unstructured{Object: map[string]interface{}{}}
-	unstructured.SetNestedField(machineSet.UnstructuredContent(), "worker", "spec", "template", "metadata", "labels", "machine.openshift.io/cluster-api-machine-role")
-	assert.Equal(true, isWorkerMachineSet(machineSet))
-}
-
 func TestHasNodesAvailable(t *testing.T) {
 	assert := assert.New(t)
 
@@ -47,21 +36,6 @@ func TestHasNodesAvailable(t *testing.T) {
 	assert.Equal(true, hasNodesAvailable(machineSet))
 }
 
-func TestNameStartsWith(t *testing.T) {
-	assert := assert.New(t)
-
-	var machineSet *unstructured.Unstructured
-
-	machineSet = &unstructured.Unstructured{}
-	assert.Equal(false, nameStartsWith(machineSet, "mycluster"))
-
-	machineSet = &unstructured.Unstructured{Object: map[string]interface{}{}}
-	unstructured.SetNestedField(machineSet.UnstructuredContent(), "mycluster", "metadata", "name")
-	assert.Equal(false, nameStartsWith(machineSet, "myprefix"))
-	assert.Equal(true, nameStartsWith(machineSet, "my"))
-	assert.Equal(true, nameStartsWith(machineSet, "mycluster"))
-}
-
 func TestIsReplicasGreaterThanZero(t *testing.T) {
 	assert := assert.New(t)
 
@@ -246,4 +220,55 @@ var _ = Describe("MachineSet controller", func() {
 			}).Should(Equal(0))
 		})
 	})
+
+	Context("When MachineSet reads tokens from a ConfigMap that is created later", func() {
+		It("Should resolve the tokens once the ConfigMap exists", func() {
+			By("Defining a MachineSet with tokens from a ConfigMap")
+			machineSet := &machineapi.MachineSet{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: "machine.openshift.io/v1beta1",
+					Kind:       "MachineSet",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "machineset4",
+					Namespace: "openshift-machine-api",
+					Annotations: map[string]string{
+						"gitops-friendly-machinesets.redhat-cop.io/enabled":     "true",
+						"gitops-friendly-machinesets.redhat-cop.io/tokens-from": "configmap/machineset4-tokens"},
+					Labels: map[string]string{
+						"example.com/subnet": "SUBNET",
+					},
+				},
+			}
+			By("Creating a MachineSet with tokens from a ConfigMap in Kubernetes")
+			err := k8sClient.Create(ctx, machineSet, &client.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			By("Checking that tokens have NOT been resolved")
+			Consistently(func() string {
+				err := k8sClient.Get(ctx,
+					types.NamespacedName{Namespace: machineSet.GetNamespace(), Name: machineSet.GetName()},
+					machineSet)
+				Expect(err).NotTo(HaveOccurred())
+				return machineSet.GetLabels()["example.com/subnet"]
+			}, time.Second).Should(Equal("SUBNET"))
+			By("Creating the ConfigMap in Kubernetes")
+			configMap := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "machineset4-tokens",
+					Namespace: "openshift-machine-api",
+				},
+				Data: map[string]string{"SUBNET": "subnet-0a1b2c"},
+			}
+			err = k8sClient.Create(ctx, configMap, &client.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			By("Waiting until the tokens have been resolved")
+			Eventually(func() string {
+				err := k8sClient.Get(ctx,
+					types.NamespacedName{Namespace: machineSet.GetNamespace(), Name: machineSet.GetName()},
+					machineSet)
+				Expect(err).NotTo(HaveOccurred())
+				return machineSet.GetLabels()["example.com/subnet"]
+			}).Should(Equal("subnet-0a1b2c"))
+		})
+	})
 })
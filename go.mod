@@ -9,8 +9,8 @@ require (
 	github.com/openshift/api v0.0.0-20211108165917-be1be0e89115
 	github.com/stretchr/testify v1.7.0
 	go.uber.org/zap v1.19.0
-	gomodules.xyz/jsonpatch/v2 v2.2.0 // indirect
-	k8s.io/api v0.22.1 // indirect
+	gomodules.xyz/jsonpatch/v2 v2.2.0
+	k8s.io/api v0.22.1
 	k8s.io/apimachinery v0.22.1
 	k8s.io/client-go v0.22.1
 	sigs.k8s.io/controller-runtime v0.10.0
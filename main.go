@@ -18,8 +18,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -28,14 +33,17 @@ import (
 
 	configapi "github.com/openshift/api/config/v1"
 	machineapi "github.com/openshift/api/machine/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	comm "github.com/noseka1/gitops-friendly-machinesets-operator/common"
 	"github.com/noseka1/gitops-friendly-machinesets-operator/controllers"
 	"github.com/noseka1/gitops-friendly-machinesets-operator/webhooks"
 	//+kubebuilder:scaffold:imports
@@ -48,6 +56,15 @@ var (
 
 const (
 	controllerName = "gitops-friendly-machinesets"
+
+	modeWebhook     = "webhook"
+	modeControllers = "controllers"
+	modeAll         = "all"
+)
+
+var (
+	clusterConfigObjectName  = client.ObjectKey{Namespace: "", Name: "cluster"}
+	clusterVersionObjectName = client.ObjectKey{Namespace: "", Name: "version"}
 )
 
 func init() {
@@ -61,11 +78,47 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var manageWebhookConfiguration bool
+	var webhookConfigurationName string
+	var webhookFailurePolicy string
+	var webhookServiceName string
+	var webhookServiceNamespace string
+	var webhookCertDir string
+	var webhookCertificates string
+	var webhookCertSecretName string
+	var mode string
+	var drainAwareDeletion bool
+	flag.StringVar(&mode, "mode", modeAll,
+		"What this instance runs: webhook, controllers or all. "+
+			"The webhook can run with several replicas, the controllers require leader election to run more than one replica.")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&manageWebhookConfiguration, "manage-webhook-configuration", true,
+		"Create and update the webhook configurations at runtime. "+
+			"The webhooks are only called for MachineSets labeled with "+comm.LabelEnabled+"=true. "+
+			"Disable when the webhook configurations are managed by OLM.")
+	flag.StringVar(&webhookConfigurationName, "webhook-configuration-name", controllerName,
+		"The base name of the webhook configurations managed by the operator. "+
+			"The names are suffixed with -mutating-webhook-configuration and -validating-webhook-configuration.")
+	flag.StringVar(&webhookFailurePolicy, "webhook-failure-policy", "Fail",
+		"The failure policy of the webhooks managed by the operator, Fail or Ignore.")
+	flag.StringVar(&webhookServiceName, "webhook-service-name", controllerName+"-webhook-service",
+		"The name of the Service that fronts the webhook server.")
+	flag.StringVar(&webhookServiceNamespace, "webhook-service-namespace", os.Getenv("POD_NAMESPACE"),
+		"The namespace of the Service that fronts the webhook server. Defaults to the namespace of the operator pod.")
+	flag.StringVar(&webhookCertDir, "webhook-cert-dir", filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs"),
+		"The directory that contains the webhook server key and certificate.")
+	flag.StringVar(&webhookCertificates, "webhook-certificates", "auto",
+		"Who provides the webhook serving certificates: self-managed, external (OLM or cert-manager) or auto. "+
+			"With auto, the certificates are self-managed if the certificate directory is writable.")
+	flag.StringVar(&webhookCertSecretName, "webhook-cert-secret-name", controllerName+"-webhook-server-cert",
+		"The name of the Secret that stores the self-managed webhook certificates.")
+	flag.BoolVar(&drainAwareDeletion, "drain-aware-machine-deletion", false,
+		"Before deleting a Machine that contains tokens, cordon its Node and wait until the PodDisruptionBudgets "+
+			"allow evicting the pods running on it.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -74,13 +127,28 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	runWebhook, runControllers, err := parseMode(mode)
+	if err != nil {
+		setupLog.Error(err, "Invalid command-line argument")
+		os.Exit(1)
+	}
+	// All webhook replicas serve requests, there is nothing to elect a leader for
+	if !runControllers && enableLeaderElection {
+		setupLog.Info("Leader election is not used in the webhook mode.")
+		enableLeaderElection = false
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
+		CertDir:                webhookCertDir,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "123eec1d.openshift.io",
+		// MachineSets, Machines and token sources only live in the openshift-machine-api namespace. The
+		// operator is only allowed to read ConfigMaps and Secrets from this namespace
+		Namespace: comm.NamespaceOpenShiftMachineApi,
 	})
 	if err != nil {
 		setupLog.Error(err, "Unable to start manager")
@@ -89,30 +157,93 @@ func main() {
 
 	restConfig := mgr.GetConfig()
 
-	infrastructureName := retrieveInfrastructureName(restConfig)
-	if infrastructureName == "" {
+	clusterInfo := retrieveClusterInfo(restConfig)
+	if clusterInfo == nil {
 		os.Exit(1)
 	}
 
-	if err = (&controllers.MachineSetReconciler{
-		Client:             mgr.GetClient(),
-		Scheme:             mgr.GetScheme(),
-		EventRecorder:      mgr.GetEventRecorderFor(controllerName),
-		InfrastructureName: infrastructureName,
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "Unable to create controller", "controller", "MachineSet")
-		os.Exit(1)
-	}
-	if err = (controllers.NewMachineReconciler(controllers.MachineReconcilerConfig{
-		Client:        mgr.GetClient(),
-		Scheme:        mgr.GetScheme(),
-		EventRecorder: mgr.GetEventRecorderFor(controllerName),
-	})).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "Unable to create controller", "controller", "Machine")
-		os.Exit(1)
+	// Token resolvers shared by the controllers and the webhook
+	resolvers := comm.NewDefaultTokenResolverRegistry()
+
+	if runControllers {
+		if err = (&controllers.MachineSetReconciler{
+			Client:        mgr.GetClient(),
+			Scheme:        mgr.GetScheme(),
+			EventRecorder: mgr.GetEventRecorderFor(controllerName),
+			ClusterInfo:   clusterInfo,
+			Resolvers:     resolvers,
+			APIReader:     mgr.GetAPIReader(),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "Unable to create controller", "controller", "MachineSet")
+			os.Exit(1)
+		}
+		if err = (controllers.NewMachineReconciler(controllers.MachineReconcilerConfig{
+			Client:             mgr.GetClient(),
+			Scheme:             mgr.GetScheme(),
+			EventRecorder:      mgr.GetEventRecorderFor(controllerName),
+			ClusterInfo:        clusterInfo,
+			Resolvers:          resolvers,
+			DrainAwareDeletion: drainAwareDeletion,
+			APIReader:          mgr.GetAPIReader(),
+		})).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "Unable to create controller", "controller", "Machine")
+			os.Exit(1)
+		}
 	}
 
-	(&webhooks.MachineSetWebhook{InfrastructureName: infrastructureName}).SetupWithManager(mgr)
+	if runWebhook {
+		(&webhooks.MachineSetWebhook{ClusterInfo: clusterInfo, Resolvers: resolvers}).SetupWithManager(mgr)
+		(&webhooks.MachineSetValidator{ClusterInfo: clusterInfo, Resolvers: resolvers}).SetupWithManager(mgr)
+		(&webhooks.MachineWebhook{ClusterInfo: clusterInfo, Resolvers: resolvers}).SetupWithManager(mgr)
+
+		if manageWebhookConfiguration {
+			failurePolicy, err := webhooks.ParseFailurePolicy(webhookFailurePolicy)
+			if err != nil {
+				setupLog.Error(err, "Invalid command-line argument")
+				os.Exit(1)
+			}
+			if err = mgr.Add(&webhooks.WebhookConfigurator{
+				Client:           mgr.GetClient(),
+				Name:             webhookConfigurationName,
+				ServiceName:      webhookServiceName,
+				ServiceNamespace: webhookServiceNamespace,
+				ServicePort:      443,
+				FailurePolicy:    failurePolicy,
+				CAFile:           filepath.Join(webhookCertDir, webhooks.CACertName),
+			}); err != nil {
+				setupLog.Error(err, "Unable to set up webhook configuration")
+				os.Exit(1)
+			}
+		}
+
+		selfManagedCertificates, err := isSelfManagedCertificates(webhookCertificates, webhookCertDir)
+		if err != nil {
+			setupLog.Error(err, "Invalid command-line argument")
+			os.Exit(1)
+		}
+		if selfManagedCertificates {
+			certificateManager := &webhooks.CertificateManager{
+				Client:      mgr.GetClient(),
+				APIReader:   mgr.GetAPIReader(),
+				SecretName:  webhookCertSecretName,
+				Namespace:   webhookServiceNamespace,
+				ServiceName: webhookServiceName,
+				CertDir:     webhookCertDir,
+			}
+			// The webhook configurations deployed from config/default and the managed ones have the same names
+			certificateManager.MutatingWebhookConfigurations = []string{webhooks.MutatingConfigurationName(webhookConfigurationName)}
+			certificateManager.ValidatingWebhookConfigurations = []string{webhooks.ValidatingConfigurationName(webhookConfigurationName)}
+			// The webhook server cannot start without the certificates
+			if _, err := certificateManager.EnsureCertificates(context.TODO()); err != nil {
+				setupLog.Error(err, "Unable to create the webhook certificates")
+				os.Exit(1)
+			}
+			if err = mgr.Add(certificateManager); err != nil {
+				setupLog.Error(err, "Unable to set up webhook certificates")
+				os.Exit(1)
+			}
+		}
+	}
 
 	//+kubebuilder:scaffold:builder
 
@@ -124,6 +255,24 @@ func main() {
 		setupLog.Error(err, "Unable to set up ready check")
 		os.Exit(1)
 	}
+	// The webhook is ready once it serves requests using valid certificates
+	if runWebhook {
+		if err := mgr.AddReadyzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
+			setupLog.Error(err, "Unable to set up ready check")
+			os.Exit(1)
+		}
+		if err := mgr.AddReadyzCheck("webhook-certificates", webhooks.CertificateChecker(webhookCertDir)); err != nil {
+			setupLog.Error(err, "Unable to set up ready check")
+			os.Exit(1)
+		}
+	}
+	// The controllers are ready once their caches have been synced
+	if runControllers {
+		if err := mgr.AddReadyzCheck("controllers", cacheSyncedChecker(mgr.GetCache())); err != nil {
+			setupLog.Error(err, "Unable to set up ready check")
+			os.Exit(1)
+		}
+	}
 
 	setupLog.Info("Starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -132,9 +281,48 @@ func main() {
 	}
 }
 
-// Retrieve unique infrastructure name of this OpenShift cluster (something like mycluster-jfnx7).
-// The code performs an equivalent of: oc get infrastructure cluster -o jsonpath='{.status.infrastructureName}'
-func retrieveInfrastructureName(clientConfig *rest.Config) string {
+// Decide whether the webhook, the controllers or both run in this instance.
+func parseMode(mode string) (bool, bool, error) {
+	switch mode {
+	case modeAll:
+		return true, true, nil
+	case modeWebhook:
+		return true, false, nil
+	case modeControllers:
+		return false, true, nil
+	}
+	return false, false, fmt.Errorf("invalid mode \"%s\", expected %s, %s or %s", mode, modeWebhook, modeControllers, modeAll)
+}
+
+// Report ready once the informer caches have been synced. The check doesn't wait for the sync.
+func cacheSyncedChecker(informerCache cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), time.Second)
+		defer cancel()
+		if !informerCache.WaitForCacheSync(ctx) {
+			return errors.New("informer caches have not been synced yet")
+		}
+		return nil
+	}
+}
+
+// Decide whether the operator generates the webhook serving certificates itself.
+func isSelfManagedCertificates(mode string, certDir string) (bool, error) {
+	switch mode {
+	case "self-managed":
+		return true, nil
+	case "external":
+		return false, nil
+	case "auto":
+		return webhooks.IsCertDirWritable(certDir), nil
+	}
+	return false, fmt.Errorf("invalid webhook certificates \"%s\", expected self-managed, external or auto", mode)
+}
+
+// Retrieve the facts about this OpenShift cluster that the tokens are resolved to. The infrastructure
+// name is mandatory. The base domain and the cluster ID are optional, tokens referring to them are
+// ignored if they cannot be retrieved.
+func retrieveClusterInfo(clientConfig *rest.Config) *comm.ClusterInfo {
 
 	configScheme := runtime.NewScheme()
 	utilruntime.Must(configapi.Install(configScheme))
@@ -142,27 +330,67 @@ func retrieveInfrastructureName(clientConfig *rest.Config) string {
 	kubeClient, err := client.New(clientConfig, client.Options{Scheme: configScheme})
 	if err != nil {
 		setupLog.Error(err, "Failed to create kube client")
-		return ""
+		return nil
+	}
+
+	infraObject := retrieveInfrastructure(kubeClient)
+	if infraObject == nil {
+		return nil
 	}
 
-	infraObjectName := client.ObjectKey{
-		Namespace: "",
-		Name:      "cluster",
+	clusterInfo := &comm.ClusterInfo{Infrastructure: infraObject.Status}
+
+	// The equivalent of: oc get dns cluster -o jsonpath='{.spec.baseDomain}'
+	dnsObject := &configapi.DNS{}
+	if err = kubeClient.Get(context.TODO(), clusterConfigObjectName, dnsObject); err != nil {
+		setupLog.Error(err, "Unable retrieve object "+clusterConfigObjectName.String()+" of kind DNS")
+	} else {
+		clusterInfo.BaseDomain = dnsObject.Spec.BaseDomain
+	}
+
+	// The equivalent of: oc get clusterversion version -o jsonpath='{.spec.clusterID}'
+	clusterVersionObject := &configapi.ClusterVersion{}
+	if err = kubeClient.Get(context.TODO(), clusterVersionObjectName, clusterVersionObject); err != nil {
+		setupLog.Error(err, "Unable retrieve object "+clusterVersionObjectName.String()+" of kind ClusterVersion")
+	} else {
+		clusterInfo.ClusterID = string(clusterVersionObject.Spec.ClusterID)
+	}
+
+	// The vSphere failure domains are not part of the OpenShift API version used by this operator,
+	// read them from the unstructured Infrastructure object
+	if infraObject.Status.PlatformStatus != nil && infraObject.Status.PlatformStatus.Type == configapi.VSpherePlatformType {
+		infraUnstructured := &unstructured.Unstructured{}
+		infraUnstructured.SetGroupVersionKind(configapi.GroupVersion.WithKind("Infrastructure"))
+		if err = kubeClient.Get(context.TODO(), clusterConfigObjectName, infraUnstructured); err != nil {
+			setupLog.Error(err, "Unable retrieve object "+clusterConfigObjectName.String()+" of kind Infrastructure")
+		} else if clusterInfo.VSphereFailureDomains, err = comm.ParseVSphereFailureDomains(infraUnstructured); err != nil {
+			setupLog.Error(err, "Unable to parse the vSphere failure domains")
+		}
 	}
+
+	setupLog.Info("Cluster facts retrieved", "tokens", comm.BuiltinTokens(clusterInfo))
+
+	return clusterInfo
+}
+
+// Retrieve the Infrastructure object including the unique infrastructure name of this OpenShift cluster
+// (something like mycluster-jfnx7).
+// The code performs an equivalent of: oc get infrastructure cluster -o jsonpath='{.status.infrastructureName}'
+func retrieveInfrastructure(kubeClient client.Client) *configapi.Infrastructure {
 	infraObject := &configapi.Infrastructure{}
 
-	if err = kubeClient.Get(context.TODO(), infraObjectName, infraObject); err != nil {
-		setupLog.Error(err, "Unable retrieve object "+infraObjectName.String()+" of kind Infrastructure")
-		return ""
+	if err := kubeClient.Get(context.TODO(), clusterConfigObjectName, infraObject); err != nil {
+		setupLog.Error(err, "Unable retrieve object "+clusterConfigObjectName.String()+" of kind Infrastructure")
+		return nil
 	}
 	infraName := infraObject.Status.InfrastructureName
 
 	if infraName == "" {
 		setupLog.Info("Infrastructure.status.infrastructureName must not be empty")
-		return ""
+		return nil
 	}
 
 	setupLog.Info("Infrastructure name is " + infraName)
 
-	return infraName
+	return infraObject
 }
@@ -12,8 +12,8 @@ import (
 var _ = Describe("Main", func() {
 
 	Context("When Infrastructure object does NOT exist", func() {
-		It("Should return an empty infrastructure name", func() {
-			Expect(retrieveInfrastructureName(clientConfig)).To(BeEmpty())
+		It("Should return no cluster info", func() {
+			Expect(retrieveClusterInfo(clientConfig)).To(BeNil())
 		})
 	})
 
@@ -51,7 +51,54 @@ var _ = Describe("Main", func() {
 				return infrastructure.Status.InfrastructureName
 			}).Should(Equal("cluster-test-xyz"))
 			By("Checking that infrastructure name is retrieved correctly")
-			Expect(retrieveInfrastructureName(clientConfig)).To(Equal("cluster-test-xyz"))
+			clusterInfo := retrieveClusterInfo(clientConfig)
+			Expect(clusterInfo).NotTo(BeNil())
+			Expect(clusterInfo.InfrastructureName()).To(Equal("cluster-test-xyz"))
+			Expect(clusterInfo.BaseDomain).To(BeEmpty())
+			Expect(clusterInfo.ClusterID).To(BeEmpty())
+		})
+	})
+
+	Context("When DNS and ClusterVersion objects do exist", func() {
+		It("Should return the base domain and the cluster ID", func() {
+			By("Defining the DNS and ClusterVersion objects")
+			dns := &configapi.DNS{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: "config.openshift.io/v1",
+					Kind:       "DNS",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "cluster",
+				},
+				Spec: configapi.DNSSpec{
+					BaseDomain: "mycluster.example.com",
+				},
+			}
+			clusterVersion := &configapi.ClusterVersion{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: "config.openshift.io/v1",
+					Kind:       "ClusterVersion",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "version",
+				},
+				Spec: configapi.ClusterVersionSpec{
+					ClusterID: "2ad8b6a6-9a4c-4c1e-b6f6-6c9a1c3f1d2e",
+				},
+			}
+			By("Creating the DNS and ClusterVersion objects in Kubernetes")
+			err := k8sClient.Create(ctx, dns, &client.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Create(ctx, clusterVersion, &client.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			By("Checking that the cluster facts are retrieved correctly")
+			Eventually(func() string {
+				clusterInfo := retrieveClusterInfo(clientConfig)
+				Expect(clusterInfo).NotTo(BeNil())
+				return clusterInfo.BaseDomain + " " + clusterInfo.ClusterID
+			}).Should(Equal("mycluster.example.com 2ad8b6a6-9a4c-4c1e-b6f6-6c9a1c3f1d2e"))
+			clusterInfo := retrieveClusterInfo(clientConfig)
+			Expect(clusterInfo.ClusterName()).To(Equal("mycluster"))
 		})
 	})
 })
@@ -0,0 +1,89 @@
+package resolvers
+
+import (
+	"strings"
+
+	configapi "github.com/openshift/api/config/v1"
+)
+
+// ClusterInfo holds the facts about this OpenShift cluster that the tokens are resolved to.
+type ClusterInfo struct {
+	Infrastructure configapi.InfrastructureStatus
+	BaseDomain     string
+	ClusterID      string
+	// Failure domains defined in Infrastructure.spec.platformSpec.vsphere
+	VSphereFailureDomains []VSphereFailureDomain
+}
+
+func (c *ClusterInfo) InfrastructureName() string {
+	return c.Infrastructure.InfrastructureName
+}
+
+// Region the cluster was deployed to. Not all platforms report a region.
+func (c *ClusterInfo) Region() string {
+	platformStatus := c.Infrastructure.PlatformStatus
+	if platformStatus == nil {
+		return ""
+	}
+	switch {
+	case platformStatus.AWS != nil:
+		return platformStatus.AWS.Region
+	case platformStatus.GCP != nil:
+		return platformStatus.GCP.Region
+	case platformStatus.IBMCloud != nil:
+		return platformStatus.IBMCloud.Location
+	case platformStatus.PowerVS != nil:
+		return platformStatus.PowerVS.Region
+	case platformStatus.AlibabaCloud != nil:
+		return platformStatus.AlibabaCloud.Region
+	}
+	return ""
+}
+
+func (c *ClusterInfo) PlatformType() string {
+	if c.Infrastructure.PlatformStatus != nil && c.Infrastructure.PlatformStatus.Type != "" {
+		return string(c.Infrastructure.PlatformStatus.Type)
+	}
+	return string(c.Infrastructure.Platform)
+}
+
+// The cluster base domain is of the form <cluster name>.<base domain from install-config>,
+// so the cluster name is its first label.
+func (c *ClusterInfo) ClusterName() string {
+	return strings.SplitN(c.BaseDomain, ".", 2)[0]
+}
+
+func (c *ClusterInfo) APIServerURL() string {
+	return c.Infrastructure.APIServerURL
+}
+
+// Failure domain of the given name, nil if the cluster doesn't define it.
+func (c *ClusterInfo) FindVSphereFailureDomain(name string) *VSphereFailureDomain {
+	for i := range c.VSphereFailureDomains {
+		if c.VSphereFailureDomains[i].Name == name {
+			return &c.VSphereFailureDomains[i]
+		}
+	}
+	return nil
+}
+
+// vSphere failure domain as defined in Infrastructure.spec.platformSpec.vsphere.failureDomains. The
+// failure domains are read from the unstructured Infrastructure object as the vendored OpenShift API
+// doesn't define them yet.
+type VSphereFailureDomain struct {
+	Name     string                       `json:"name"`
+	Region   string                       `json:"region,omitempty"`
+	Zone     string                       `json:"zone,omitempty"`
+	Server   string                       `json:"server"`
+	Topology VSphereFailureDomainTopology `json:"topology"`
+}
+
+type VSphereFailureDomainTopology struct {
+	Datacenter     string   `json:"datacenter"`
+	ComputeCluster string   `json:"computeCluster,omitempty"`
+	Networks       []string `json:"networks,omitempty"`
+	Datastore      string   `json:"datastore"`
+	ResourcePool   string   `json:"resourcePool,omitempty"`
+	Folder         string   `json:"folder,omitempty"`
+	Template       string   `json:"template,omitempty"`
+}
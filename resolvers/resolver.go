@@ -0,0 +1,69 @@
+package resolvers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// TokenResolver produces additional tokens for a MachineSet or one of its Machines. Implement this
+// interface to plug custom token lookups into the operator. The returned tokens are offered the same way
+// as the built-in tokens: they are replaced only if listed in the tokens annotation of the object. A token
+// that doesn't apply to the object should be returned with an empty value, it is then left untouched.
+type TokenResolver interface {
+	// Name of the resolver used in log messages
+	Name() string
+	// Resolve the tokens for the given MachineSet or Machine
+	ResolveTokens(ctx context.Context, obj *unstructured.Unstructured, cluster *ClusterInfo) (map[string]string, error)
+}
+
+// TokenResolverRegistry holds the token resolvers consulted by the MachineSet and Machine reconcilers and
+// by the MachineSet webhook. It is safe for concurrent use.
+type TokenResolverRegistry struct {
+	mutex     sync.RWMutex
+	resolvers []TokenResolver
+}
+
+func NewTokenResolverRegistry(resolvers ...TokenResolver) *TokenResolverRegistry {
+	return &TokenResolverRegistry{resolvers: resolvers}
+}
+
+// Add a resolver to the registry. Tokens from resolvers registered later take precedence.
+func (r *TokenResolverRegistry) Register(resolver TokenResolver) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.resolvers = append(r.resolvers, resolver)
+}
+
+func (r *TokenResolverRegistry) Resolvers() []TokenResolver {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return append([]TokenResolver{}, r.resolvers...)
+}
+
+// Consult all registered resolvers and merge the tokens they return. A nil registry resolves no tokens.
+func (r *TokenResolverRegistry) ResolveTokens(ctx context.Context, logger logr.Logger, obj *unstructured.Unstructured, cluster *ClusterInfo) (map[string]string, error) {
+	tokens := map[string]string{}
+	if r == nil {
+		return tokens, nil
+	}
+
+	for _, resolver := range r.Resolvers() {
+		resolved, err := resolver.ResolveTokens(ctx, obj, cluster)
+		if err != nil {
+			logger.Error(err, "Token resolver \""+resolver.Name()+"\" failed.")
+			return nil, err
+		}
+		for name, value := range resolved {
+			if previous, found := tokens[name]; found && previous != "" && value != "" {
+				logger.Info("Token \"" + name + "\" is overridden by token resolver \"" + resolver.Name() + "\".")
+			}
+			if _, found := tokens[name]; !found || value != "" {
+				tokens[name] = value
+			}
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,54 @@
+package resolvers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	configapi "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type testTokenResolver struct {
+	tokens map[string]string
+	err    error
+}
+
+func (r testTokenResolver) Name() string {
+	return "test"
+}
+
+func (r testTokenResolver) ResolveTokens(ctx context.Context, obj *unstructured.Unstructured, cluster *ClusterInfo) (map[string]string, error) {
+	return r.tokens, r.err
+}
+
+func TestTokenResolverRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	cluster := &ClusterInfo{
+		Infrastructure: configapi.InfrastructureStatus{
+			InfrastructureName: "mycluster-jfnx7",
+			PlatformStatus:     &configapi.PlatformStatus{Type: configapi.VSpherePlatformType},
+		},
+	}
+	input := &unstructured.Unstructured{}
+
+	var registry *TokenResolverRegistry
+	tokens, err := registry.ResolveTokens(context.TODO(), logr.Discard(), input, cluster)
+	assert.Nil(err)
+	assert.Empty(tokens)
+
+	// Later resolvers take precedence, empty values don't override
+	registry = NewTokenResolverRegistry(
+		testTokenResolver{tokens: map[string]string{"IPAM_ADDRESS": "10.0.0.1", "IPAM_GATEWAY": "10.0.0.254"}})
+	registry.Register(testTokenResolver{tokens: map[string]string{"IPAM_ADDRESS": "10.0.0.2", "IPAM_GATEWAY": ""}})
+	tokens, err = registry.ResolveTokens(context.TODO(), logr.Discard(), input, cluster)
+	assert.Nil(err)
+	assert.Equal(map[string]string{"IPAM_ADDRESS": "10.0.0.2", "IPAM_GATEWAY": "10.0.0.254"}, tokens)
+
+	registry.Register(testTokenResolver{err: errors.New("lookup failed")})
+	_, err = registry.ResolveTokens(context.TODO(), logr.Discard(), input, cluster)
+	assert.NotNil(err)
+}
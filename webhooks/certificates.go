@@ -0,0 +1,440 @@
+/*
+Copyright 2021 Ales Nosek.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-logr/logr"
+	comm "github.com/noseka1/gitops-friendly-machinesets-operator/common"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// Keys of the certificate Secret, the serving certificate files use the same names
+	CACertName      string = "ca.crt"
+	CAKeyName       string = "ca.key"
+	ServingCertName string = corev1.TLSCertKey
+	ServingKeyName  string = corev1.TLSPrivateKeyKey
+
+	caValidity      = 10 * 365 * 24 * time.Hour
+	servingValidity = 365 * 24 * time.Hour
+	// Certificates are renewed when they are about to expire within this period
+	certificateRenewBefore = 30 * 24 * time.Hour
+
+	// How often the certificates are checked for expiry
+	DefaultCertificateCheckPeriod = time.Hour
+)
+
+// CertificateManager generates a CA and a serving certificate for the webhook server, so that the
+// operator doesn't depend on cert-manager or OLM to provide them. The certificates are stored in a
+// Secret shared by all replicas and written to the certificate directory of the webhook server. The CA
+// certificate is injected into the webhook configurations. Both certificates are renewed before they
+// expire.
+type CertificateManager struct {
+	client.Client
+	// Uncached reader, the manager cache doesn't cover the operator namespace
+	APIReader client.Reader
+	// Secret holding the certificates
+	SecretName string
+	Namespace  string
+	// Service that fronts the webhook server, the serving certificate is issued for its DNS names
+	ServiceName string
+	CertDir     string
+	// Webhook configurations that the CA certificate is injected into
+	MutatingWebhookConfigurations   []string
+	ValidatingWebhookConfigurations []string
+	CheckPeriod                     time.Duration
+}
+
+// Renew the certificates and inject the CA certificate periodically until the context is done.
+func (m *CertificateManager) Start(ctx context.Context) error {
+	logger := m.logger(ctx)
+
+	checkPeriod := m.CheckPeriod
+	if checkPeriod <= 0 {
+		checkPeriod = DefaultCertificateCheckPeriod
+	}
+	ticker := time.NewTicker(checkPeriod)
+	defer ticker.Stop()
+
+	for {
+		// A failure is not fatal, the current certificates stay valid and the check is retried later
+		caBundle, err := m.EnsureCertificates(ctx)
+		if err != nil {
+			logger.Error(err, "Failed to renew the webhook certificates.")
+		} else if err = m.InjectCABundle(ctx, caBundle); err != nil {
+			logger.Error(err, "Failed to inject the CA certificate into the webhook configurations.")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Every replica serving the webhook writes the certificates to its own certificate directory.
+func (m *CertificateManager) NeedLeaderElection() bool {
+	return false
+}
+
+// Make sure that the Secret holds valid certificates and write them to the certificate directory.
+// Returns the PEM-encoded CA certificate.
+func (m *CertificateManager) EnsureCertificates(ctx context.Context) ([]byte, error) {
+	logger := m.logger(ctx)
+
+	secret := &corev1.Secret{}
+	err := m.APIReader.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: m.SecretName}, secret)
+	secretFound := err == nil
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	data, renewed, err := renewCertificates(logger, secret.Data, ServiceDNSNames(m.ServiceName, m.Namespace), time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if renewed {
+		secret.Data = data
+		if secretFound {
+			err = m.Update(ctx, secret)
+		} else {
+			secret.Name = m.SecretName
+			secret.Namespace = m.Namespace
+			secret.Type = corev1.SecretTypeTLS
+			err = m.Create(ctx, secret)
+		}
+		if apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err) {
+			// Another replica stored its certificates at the same time, use them instead
+			logger.Info("Webhook certificates were renewed by another replica.")
+			data, err = m.readStoredCertificates(ctx, logger)
+			if err != nil {
+				return nil, err
+			}
+		} else if err != nil {
+			return nil, err
+		} else {
+			logger.Info("Webhook certificates renewed.")
+		}
+	}
+
+	for _, name := range []string{CACertName, ServingKeyName, ServingCertName} {
+		if err = writeFileIfChanged(filepath.Join(m.CertDir, name), data[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	return data[CACertName], nil
+}
+
+// Read the certificates stored in the Secret by another replica. Fails if they are not valid.
+func (m *CertificateManager) readStoredCertificates(ctx context.Context, logger logr.Logger) (map[string][]byte, error) {
+	secret := &corev1.Secret{}
+	err := m.APIReader.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: m.SecretName}, secret)
+	if err != nil {
+		return nil, err
+	}
+	_, renewed, err := renewCertificates(logger, secret.Data, ServiceDNSNames(m.ServiceName, m.Namespace), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if renewed {
+		return nil, errors.New("certificates stored in Secret " + m.Namespace + "/" + m.SecretName + " are not valid")
+	}
+	return secret.Data, nil
+}
+
+// Set the caBundle of all webhooks in the webhook configurations. A webhook configuration that doesn't
+// exist is skipped.
+func (m *CertificateManager) InjectCABundle(ctx context.Context, caBundle []byte) error {
+	logger := m.logger(ctx)
+
+	for _, name := range m.MutatingWebhookConfigurations {
+		config := &admissionregistrationv1.MutatingWebhookConfiguration{}
+		if err := m.APIReader.Get(ctx, client.ObjectKey{Name: name}, config); err != nil {
+			if apierrors.IsNotFound(err) {
+				logger.V(1).Info("MutatingWebhookConfiguration \"" + name + "\" not found. Skipping it.")
+				continue
+			}
+			return err
+		}
+		changed := false
+		for i := range config.Webhooks {
+			if !bytes.Equal(config.Webhooks[i].ClientConfig.CABundle, caBundle) {
+				config.Webhooks[i].ClientConfig.CABundle = caBundle
+				changed = true
+			}
+		}
+		if changed {
+			if err := m.Update(ctx, config); err != nil {
+				return err
+			}
+			logger.Info("CA certificate injected into MutatingWebhookConfiguration \"" + name + "\".")
+		}
+	}
+
+	for _, name := range m.ValidatingWebhookConfigurations {
+		config := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+		if err := m.APIReader.Get(ctx, client.ObjectKey{Name: name}, config); err != nil {
+			if apierrors.IsNotFound(err) {
+				logger.V(1).Info("ValidatingWebhookConfiguration \"" + name + "\" not found. Skipping it.")
+				continue
+			}
+			return err
+		}
+		changed := false
+		for i := range config.Webhooks {
+			if !bytes.Equal(config.Webhooks[i].ClientConfig.CABundle, caBundle) {
+				config.Webhooks[i].ClientConfig.CABundle = caBundle
+				changed = true
+			}
+		}
+		if changed {
+			if err := m.Update(ctx, config); err != nil {
+				return err
+			}
+			logger.Info("CA certificate injected into ValidatingWebhookConfiguration \"" + name + "\".")
+		}
+	}
+
+	return nil
+}
+
+func (m *CertificateManager) logger(ctx context.Context) logr.Logger {
+	return log.FromContext(ctx).WithName("webhook.certificates").WithValues(
+		comm.FieldNamespace, m.Namespace, comm.FieldName, m.SecretName)
+}
+
+// DNS names under which the API server reaches the webhook Service.
+func ServiceDNSNames(serviceName string, namespace string) []string {
+	return []string{
+		serviceName + "." + namespace + ".svc",
+		serviceName + "." + namespace + ".svc.cluster.local",
+	}
+}
+
+// Check the certificates found in the Secret data and generate the ones that are missing, invalid or
+// about to expire. A new CA always comes with a new serving certificate. The CA certificate comes first
+// in ca.crt, it may be followed by the previous CA certificate. Returns the resulting Secret data and
+// whether any certificate was generated.
+func renewCertificates(logger logr.Logger, data map[string][]byte, dnsNames []string, now time.Time) (map[string][]byte, bool, error) {
+	renewed := map[string][]byte{}
+	for key, value := range data {
+		renewed[key] = value
+	}
+
+	caCert, caKey, err := parseCA(renewed[CACertName], renewed[CAKeyName])
+	caValid := err == nil && now.Add(certificateRenewBefore).Before(caCert.NotAfter)
+	if !caValid {
+		logger.Info("Generating a new webhook CA certificate.")
+		certPEM, keyPEM, err := generateCA(now)
+		if err != nil {
+			return nil, false, err
+		}
+		// Keep trusting the previous CA until it expires, the other replicas may still serve a
+		// certificate signed by it
+		caBundle := certPEM
+		if caCert != nil && now.Before(caCert.NotAfter) {
+			caBundle = append(caBundle, encodeCertificate(caCert.Raw)...)
+		}
+		renewed[CACertName] = caBundle
+		renewed[CAKeyName] = keyPEM
+		if caCert, caKey, err = parseCA(certPEM, keyPEM); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if caValid && isServingCertificateValid(renewed[ServingCertName], renewed[ServingKeyName], caCert, dnsNames, now) {
+		return renewed, false, nil
+	}
+
+	logger.Info("Generating a new webhook serving certificate.")
+	certPEM, keyPEM, err := generateServingCertificate(caCert, caKey, dnsNames, now)
+	if err != nil {
+		return nil, false, err
+	}
+	renewed[ServingCertName] = certPEM
+	renewed[ServingKeyName] = keyPEM
+
+	return renewed, true, nil
+}
+
+func isServingCertificateValid(certPEM []byte, keyPEM []byte, caCert *x509.Certificate, dnsNames []string, now time.Time) bool {
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return false
+	}
+	if cert.CheckSignatureFrom(caCert) != nil || !now.Add(certificateRenewBefore).Before(cert.NotAfter) {
+		return false
+	}
+	for _, dnsName := range dnsNames {
+		if cert.VerifyHostname(dnsName) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func parseCA(certPEM []byte, keyPEM []byte) (*x509.Certificate, *rsa.PrivateKey, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, errors.New("CA certificate or key not found")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func generateCA(now time.Time) ([]byte, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: "gitops-friendly-machinesets-webhook-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodeCertificate(der), encodePrivateKey(key), nil
+}
+
+func generateServingCertificate(caCert *x509.Certificate, caKey *rsa.PrivateKey, dnsNames []string, now time.Time) ([]byte, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(servingValidity),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodeCertificate(der), encodePrivateKey(key), nil
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func encodeCertificate(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func encodePrivateKey(key *rsa.PrivateKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+// Report ready once the serving certificate has been loaded from the certificate directory and as long
+// as it hasn't expired.
+func CertificateChecker(certDir string) healthz.Checker {
+	return func(_ *http.Request) error {
+		keyPair, err := tls.LoadX509KeyPair(filepath.Join(certDir, ServingCertName), filepath.Join(certDir, ServingKeyName))
+		if err != nil {
+			return err
+		}
+		cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+		if err != nil {
+			return err
+		}
+		if time.Now().After(cert.NotAfter) {
+			return errors.New("webhook serving certificate expired")
+		}
+		return nil
+	}
+}
+
+// Certificate Secrets and the volumes mounted by OLM are read-only, a writable certificate directory
+// means that nobody else provides the certificates.
+func IsCertDirWritable(certDir string) bool {
+	if err := os.MkdirAll(certDir, 0700); err != nil {
+		return false
+	}
+	file, err := ioutil.TempFile(certDir, ".probe")
+	if err != nil {
+		return false
+	}
+	file.Close()
+	os.Remove(file.Name())
+	return true
+}
+
+// The webhook server watches the certificate files, only write them if their content changed
+func writeFileIfChanged(path string, content []byte) error {
+	existing, err := ioutil.ReadFile(path)
+	if err == nil && bytes.Equal(existing, content) {
+		return nil
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0600)
+}
@@ -0,0 +1,196 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRenewCertificates(t *testing.T) {
+	assert := assert.New(t)
+	logger := logr.Discard()
+
+	dnsNames := ServiceDNSNames("webhook-service", "gitops-friendly-machinesets")
+	now := time.Now()
+
+	// Generate all certificates
+	data, renewed, err := renewCertificates(logger, nil, dnsNames, now)
+	assert.Nil(err)
+	assert.True(renewed)
+	for _, key := range []string{CACertName, CAKeyName, ServingCertName, ServingKeyName} {
+		assert.NotEmpty(data[key])
+	}
+
+	// Valid certificates are kept
+	kept, renewed, err := renewCertificates(logger, data, dnsNames, now)
+	assert.Nil(err)
+	assert.False(renewed)
+	assert.Equal(data, kept)
+
+	// The serving certificate is renewed before it expires, the CA is kept
+	later := now.Add(servingValidity - certificateRenewBefore/2)
+	servingRenewed, renewed, err := renewCertificates(logger, data, dnsNames, later)
+	assert.Nil(err)
+	assert.True(renewed)
+	assert.Equal(data[CACertName], servingRenewed[CACertName])
+	assert.NotEqual(data[ServingCertName], servingRenewed[ServingCertName])
+
+	// The serving certificate is renewed if the Service changed
+	otherNames := ServiceDNSNames("other-service", "gitops-friendly-machinesets")
+	servingRenewed, renewed, err = renewCertificates(logger, data, otherNames, now)
+	assert.Nil(err)
+	assert.True(renewed)
+	assert.Equal(data[CACertName], servingRenewed[CACertName])
+	assert.NotEqual(data[ServingCertName], servingRenewed[ServingCertName])
+
+	// A renewed CA comes with a new serving certificate, the previous CA is still trusted
+	later = now.Add(caValidity - certificateRenewBefore/2)
+	caRenewed, renewed, err := renewCertificates(logger, data, dnsNames, later)
+	assert.Nil(err)
+	assert.True(renewed)
+	assert.NotEqual(data[CAKeyName], caRenewed[CAKeyName])
+	assert.NotEqual(data[ServingCertName], caRenewed[ServingCertName])
+	assert.True(bytes.HasSuffix(caRenewed[CACertName], data[CACertName]))
+	caCert, _, err := parseCA(caRenewed[CACertName], caRenewed[CAKeyName])
+	assert.Nil(err)
+	assert.True(isServingCertificateValid(caRenewed[ServingCertName], caRenewed[ServingKeyName], caCert, dnsNames, later))
+}
+
+func TestEnsureCertificates(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	mutating := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "gitops-friendly-machinesets-mutating-webhook-configuration"},
+		Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: mutatingWebhookName}},
+	}
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(mutating).Build()
+
+	manager := &CertificateManager{
+		Client:                          fakeClient,
+		APIReader:                       fakeClient,
+		SecretName:                      "gitops-friendly-machinesets-webhook-server-cert",
+		Namespace:                       "gitops-friendly-machinesets",
+		ServiceName:                     "gitops-friendly-machinesets-webhook-service",
+		CertDir:                         t.TempDir(),
+		MutatingWebhookConfigurations:   []string{mutating.Name},
+		ValidatingWebhookConfigurations: []string{"not-found"},
+	}
+
+	caBundle, err := manager.EnsureCertificates(ctx)
+	assert.Nil(err)
+	assert.NotEmpty(caBundle)
+
+	// The certificates are stored in the Secret and written to the certificate directory
+	secret := &corev1.Secret{}
+	assert.Nil(fakeClient.Get(ctx, client.ObjectKey{Namespace: manager.Namespace, Name: manager.SecretName}, secret))
+	assert.Equal(corev1.SecretTypeTLS, secret.Type)
+	for _, name := range []string{CACertName, ServingCertName, ServingKeyName} {
+		content, err := ioutil.ReadFile(filepath.Join(manager.CertDir, name))
+		assert.Nil(err)
+		assert.Equal(secret.Data[name], content)
+	}
+
+	// The existing certificates are reused
+	sameBundle, err := manager.EnsureCertificates(ctx)
+	assert.Nil(err)
+	assert.Equal(caBundle, sameBundle)
+
+	// The CA certificate is injected into the existing webhook configurations
+	assert.Nil(manager.InjectCABundle(ctx, caBundle))
+	assert.Nil(fakeClient.Get(ctx, client.ObjectKey{Name: mutating.Name}, mutating))
+	assert.Equal(caBundle, mutating.Webhooks[0].ClientConfig.CABundle)
+}
+
+// Reader that doesn't see the Secret the first time, as if another replica created it right after.
+type lateSecretReader struct {
+	client.Reader
+	seen bool
+}
+
+func (r *lateSecretReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if _, ok := obj.(*corev1.Secret); ok && !r.seen {
+		r.seen = true
+		return apierrors.NewNotFound(corev1.Resource("secrets"), key.Name)
+	}
+	return r.Reader.Get(ctx, key, obj)
+}
+
+func TestEnsureCertificatesConcurrently(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	newManager := func(reader client.Reader) *CertificateManager {
+		return &CertificateManager{
+			Client:      fakeClient,
+			APIReader:   reader,
+			SecretName:  "gitops-friendly-machinesets-webhook-server-cert",
+			Namespace:   "gitops-friendly-machinesets",
+			ServiceName: "gitops-friendly-machinesets-webhook-service",
+			CertDir:     t.TempDir(),
+		}
+	}
+
+	// The first replica stores its certificates
+	first := newManager(fakeClient)
+	caBundle, err := first.EnsureCertificates(ctx)
+	assert.Nil(err)
+
+	// The second replica fails to create the Secret and uses the stored certificates
+	second := newManager(&lateSecretReader{Reader: fakeClient})
+	sameBundle, err := second.EnsureCertificates(ctx)
+	assert.Nil(err)
+	assert.Equal(caBundle, sameBundle)
+	for _, name := range []string{CACertName, ServingCertName, ServingKeyName} {
+		content, err := ioutil.ReadFile(filepath.Join(second.CertDir, name))
+		assert.Nil(err)
+		expected, err := ioutil.ReadFile(filepath.Join(first.CertDir, name))
+		assert.Nil(err)
+		assert.Equal(expected, content)
+	}
+}
+
+func TestIsCertDirWritable(t *testing.T) {
+	assert := assert.New(t)
+
+	certDir := filepath.Join(t.TempDir(), "serving-certs")
+	assert.True(IsCertDirWritable(certDir))
+	files, err := ioutil.ReadDir(certDir)
+	assert.Nil(err)
+	assert.Empty(files)
+}
+
+func TestCertificateChecker(t *testing.T) {
+	assert := assert.New(t)
+
+	certDir := t.TempDir()
+	checker := CertificateChecker(certDir)
+	assert.NotNil(checker(nil))
+
+	data, _, err := renewCertificates(logr.Discard(), nil, ServiceDNSNames("webhook-service", "default"), time.Now())
+	assert.Nil(err)
+	for _, name := range []string{ServingCertName, ServingKeyName} {
+		assert.Nil(ioutil.WriteFile(filepath.Join(certDir, name), data[name], 0600))
+	}
+	assert.Nil(checker(nil))
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2021 Ales Nosek.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	comm "github.com/noseka1/gitops-friendly-machinesets-operator/common"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+//+kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations;validatingwebhookconfigurations,verbs=get;list;watch;create;update;patch
+
+const (
+	mutatingWebhookName   string = "gitops-friendly-machinesets.kb.io"
+	validatingWebhookName string = "validate.gitops-friendly-machinesets.kb.io"
+	machineWebhookName    string = "machine.gitops-friendly-machinesets.kb.io"
+
+	// How often the webhook configurations are re-applied to revert manual changes
+	DefaultConfigurationResyncPeriod = 10 * time.Minute
+)
+
+// Parse the failure policy given on the command line. The policy is case-insensitive.
+func ParseFailurePolicy(policy string) (admissionregistrationv1.FailurePolicyType, error) {
+	switch strings.ToLower(policy) {
+	case "fail":
+		return admissionregistrationv1.Fail, nil
+	case "ignore":
+		return admissionregistrationv1.Ignore, nil
+	}
+	return "", fmt.Errorf("invalid webhook failure policy \"%s\", expected Fail or Ignore", policy)
+}
+
+// Name of the MutatingWebhookConfiguration. It is the name of the configuration deployed from
+// config/default, the operator takes it over.
+func MutatingConfigurationName(name string) string {
+	return name + "-mutating-webhook-configuration"
+}
+
+// Name of the ValidatingWebhookConfiguration. It is the name of the configuration deployed from
+// config/default, the operator takes it over.
+func ValidatingConfigurationName(name string) string {
+	return name + "-validating-webhook-configuration"
+}
+
+// WebhookConfigurator creates and updates the MutatingWebhookConfiguration and the
+// ValidatingWebhookConfiguration of this operator at runtime. The webhooks are scoped by an object
+// selector, only MachineSets that carry the comm.LabelEnabled label are sent to the operator. That way
+// the operator can never block changes to unrelated MachineSets, even if it is not running.
+type WebhookConfigurator struct {
+	client.Client
+	// Base name of the webhook configuration objects, see MutatingConfigurationName and
+	// ValidatingConfigurationName
+	Name string
+	// Service that fronts the webhook server
+	ServiceName      string
+	ServiceNamespace string
+	ServicePort      int32
+	// With the Ignore policy, MachineSets are admitted unchanged while the webhook is unavailable.
+	// The MachineSet controller replaces their tokens afterwards.
+	FailurePolicy admissionregistrationv1.FailurePolicyType
+	// File holding the PEM-encoded CA certificate that signed the webhook serving certificate. It is
+	// re-read on every update to follow the certificate rotation. If the file doesn't exist, the caBundle
+	// found in the webhook configurations is preserved, so that it can be injected by another party.
+	CAFile       string
+	ResyncPeriod time.Duration
+}
+
+// Apply the webhook configurations and keep re-applying them until the context is done.
+func (c *WebhookConfigurator) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("webhook.configuration").WithValues(comm.FieldName, c.Name)
+
+	resyncPeriod := c.ResyncPeriod
+	if resyncPeriod <= 0 {
+		resyncPeriod = DefaultConfigurationResyncPeriod
+	}
+	ticker := time.NewTicker(resyncPeriod)
+	defer ticker.Stop()
+
+	for {
+		// A failure is not fatal, the webhook server keeps running and the update is retried later
+		if err := c.EnsureConfigurations(ctx); err != nil {
+			logger.Error(err, "Failed to apply the webhook configurations.")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Every replica serving the webhook keeps the configurations up to date.
+func (c *WebhookConfigurator) NeedLeaderElection() bool {
+	return false
+}
+
+// Create or update the MutatingWebhookConfiguration and the ValidatingWebhookConfiguration.
+func (c *WebhookConfigurator) EnsureConfigurations(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("webhook.configuration").WithValues(comm.FieldName, c.Name)
+
+	caBundle, err := c.readCABundle()
+	if err != nil {
+		return err
+	}
+
+	mutating := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: MutatingConfigurationName(c.Name)},
+	}
+	result, err := controllerutil.CreateOrUpdate(ctx, c.Client, mutating, func() error {
+		mutating.Webhooks = []admissionregistrationv1.MutatingWebhook{
+			c.mutatingWebhook(mutatingWebhookName, webhookPath,
+				rules("machinesets", admissionregistrationv1.Create, admissionregistrationv1.Update),
+				c.failurePolicy(), caBundle, existingMutatingCABundle(mutating, mutatingWebhookName)),
+			// Never block machine-api from creating Machines, the Machine controller is the fallback
+			c.mutatingWebhook(machineWebhookName, machineWebhookPath,
+				rules("machines", admissionregistrationv1.Create),
+				admissionregistrationv1.Ignore, caBundle, existingMutatingCABundle(mutating, machineWebhookName)),
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if result != controllerutil.OperationResultNone {
+		logger.Info("MutatingWebhookConfiguration " + string(result) + ".")
+	}
+
+	validating := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: ValidatingConfigurationName(c.Name)},
+	}
+	result, err = controllerutil.CreateOrUpdate(ctx, c.Client, validating, func() error {
+		validating.Webhooks = []admissionregistrationv1.ValidatingWebhook{
+			c.validatingWebhook(caBundle, existingValidatingCABundle(validating))}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if result != controllerutil.OperationResultNone {
+		logger.Info("ValidatingWebhookConfiguration " + string(result) + ".")
+	}
+
+	return nil
+}
+
+// The fields defaulted by the API server are set explicitly, so that re-applying an unchanged
+// configuration doesn't result in an update.
+func (c *WebhookConfigurator) mutatingWebhook(name string, path string, rules []admissionregistrationv1.RuleWithOperations,
+	failurePolicy admissionregistrationv1.FailurePolicyType, caBundle, existingCABundle []byte) admissionregistrationv1.MutatingWebhook {
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	matchPolicy := admissionregistrationv1.Equivalent
+	reinvocationPolicy := admissionregistrationv1.NeverReinvocationPolicy
+	timeoutSeconds := int32(10)
+	return admissionregistrationv1.MutatingWebhook{
+		Name:                    name,
+		ClientConfig:            c.clientConfig(path, caBundle, existingCABundle),
+		Rules:                   rules,
+		FailurePolicy:           &failurePolicy,
+		MatchPolicy:             &matchPolicy,
+		NamespaceSelector:       &metav1.LabelSelector{},
+		ObjectSelector:          objectSelector(),
+		SideEffects:             &sideEffects,
+		TimeoutSeconds:          &timeoutSeconds,
+		AdmissionReviewVersions: []string{"v1", "v1beta1"},
+		ReinvocationPolicy:      &reinvocationPolicy,
+	}
+}
+
+func (c *WebhookConfigurator) validatingWebhook(caBundle, existingCABundle []byte) admissionregistrationv1.ValidatingWebhook {
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	failurePolicy := c.failurePolicy()
+	matchPolicy := admissionregistrationv1.Equivalent
+	timeoutSeconds := int32(10)
+	return admissionregistrationv1.ValidatingWebhook{
+		Name:                    validatingWebhookName,
+		ClientConfig:            c.clientConfig(validatingWebhookPath, caBundle, existingCABundle),
+		Rules:                   rules("machinesets", admissionregistrationv1.Create, admissionregistrationv1.Update),
+		FailurePolicy:           &failurePolicy,
+		MatchPolicy:             &matchPolicy,
+		NamespaceSelector:       &metav1.LabelSelector{},
+		ObjectSelector:          objectSelector(),
+		SideEffects:             &sideEffects,
+		TimeoutSeconds:          &timeoutSeconds,
+		AdmissionReviewVersions: []string{"v1", "v1beta1"},
+	}
+}
+
+func (c *WebhookConfigurator) readCABundle() ([]byte, error) {
+	if c.CAFile == "" {
+		return nil, nil
+	}
+	caBundle, err := ioutil.ReadFile(c.CAFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return caBundle, err
+}
+
+func (c *WebhookConfigurator) failurePolicy() admissionregistrationv1.FailurePolicyType {
+	if c.FailurePolicy == "" {
+		return admissionregistrationv1.Fail
+	}
+	return c.FailurePolicy
+}
+
+func (c *WebhookConfigurator) clientConfig(path string, caBundle, existingCABundle []byte) admissionregistrationv1.WebhookClientConfig {
+	if len(caBundle) == 0 {
+		caBundle = existingCABundle
+	}
+	port := c.ServicePort
+	return admissionregistrationv1.WebhookClientConfig{
+		Service: &admissionregistrationv1.ServiceReference{
+			Namespace: c.ServiceNamespace,
+			Name:      c.ServiceName,
+			Path:      &path,
+			Port:      &port,
+		},
+		CABundle: caBundle,
+	}
+}
+
+func rules(resource string, operations ...admissionregistrationv1.OperationType) []admissionregistrationv1.RuleWithOperations {
+	scope := admissionregistrationv1.AllScopes
+	return []admissionregistrationv1.RuleWithOperations{
+		{
+			Operations: operations,
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{"machine.openshift.io"},
+				APIVersions: []string{"v1beta1"},
+				Resources:   []string{resource},
+				Scope:       &scope,
+			},
+		},
+	}
+}
+
+// Only MachineSets labeled by the user, and the Machines created from them, are sent to the webhooks
+func objectSelector() *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchLabels: map[string]string{comm.LabelEnabled: "true"},
+	}
+}
+
+func existingMutatingCABundle(config *admissionregistrationv1.MutatingWebhookConfiguration, name string) []byte {
+	for _, webhook := range config.Webhooks {
+		if webhook.Name == name {
+			return webhook.ClientConfig.CABundle
+		}
+	}
+	return nil
+}
+
+func existingValidatingCABundle(config *admissionregistrationv1.ValidatingWebhookConfiguration) []byte {
+	for _, webhook := range config.Webhooks {
+		if webhook.Name == validatingWebhookName {
+			return webhook.ClientConfig.CABundle
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package webhooks
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	comm "github.com/noseka1/gitops-friendly-machinesets-operator/common"
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newConfigurator(t *testing.T, caBundle []byte) *WebhookConfigurator {
+	caFile := filepath.Join(t.TempDir(), CACertName)
+	if caBundle != nil {
+		_ = ioutil.WriteFile(caFile, caBundle, 0600)
+	}
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	return &WebhookConfigurator{
+		Client:           fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Name:             "gitops-friendly-machinesets",
+		ServiceName:      "gitops-friendly-machinesets-webhook-service",
+		ServiceNamespace: "gitops-friendly-machinesets",
+		ServicePort:      443,
+		FailurePolicy:    admissionregistrationv1.Ignore,
+		CAFile:           caFile,
+	}
+}
+
+func TestParseFailurePolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	policy, err := ParseFailurePolicy("Fail")
+	assert.Nil(err)
+	assert.Equal(admissionregistrationv1.Fail, policy)
+
+	policy, err = ParseFailurePolicy("ignore")
+	assert.Nil(err)
+	assert.Equal(admissionregistrationv1.Ignore, policy)
+
+	_, err = ParseFailurePolicy("retry")
+	assert.NotNil(err)
+}
+
+func TestEnsureConfigurations(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	configurator := newConfigurator(t, []byte("ca"))
+	assert.Nil(configurator.EnsureConfigurations(ctx))
+
+	mutatingKey := client.ObjectKey{Name: "gitops-friendly-machinesets-mutating-webhook-configuration"}
+	validatingKey := client.ObjectKey{Name: "gitops-friendly-machinesets-validating-webhook-configuration"}
+
+	mutating := &admissionregistrationv1.MutatingWebhookConfiguration{}
+	assert.Nil(configurator.Get(ctx, mutatingKey, mutating))
+	assert.Len(mutating.Webhooks, 2)
+	webhook := mutating.Webhooks[0]
+	assert.Equal(mutatingWebhookName, webhook.Name)
+	assert.Equal(admissionregistrationv1.Ignore, *webhook.FailurePolicy)
+	assert.Equal(map[string]string{comm.LabelEnabled: "true"}, webhook.ObjectSelector.MatchLabels)
+	assert.Equal(webhookPath, *webhook.ClientConfig.Service.Path)
+	assert.Equal("gitops-friendly-machinesets-webhook-service", webhook.ClientConfig.Service.Name)
+	assert.Equal([]byte("ca"), webhook.ClientConfig.CABundle)
+
+	// The Machine webhook never blocks machine-api
+	machineWebhook := mutating.Webhooks[1]
+	assert.Equal(machineWebhookName, machineWebhook.Name)
+	assert.Equal(admissionregistrationv1.Ignore, *machineWebhook.FailurePolicy)
+	assert.Equal([]string{"machines"}, machineWebhook.Rules[0].Resources)
+	assert.Equal([]admissionregistrationv1.OperationType{admissionregistrationv1.Create}, machineWebhook.Rules[0].Operations)
+	assert.Equal(machineWebhookPath, *machineWebhook.ClientConfig.Service.Path)
+
+	validating := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+	assert.Nil(configurator.Get(ctx, validatingKey, validating))
+	assert.Len(validating.Webhooks, 1)
+	assert.Equal(validatingWebhookName, validating.Webhooks[0].Name)
+	assert.Equal(validatingWebhookPath, *validating.Webhooks[0].ClientConfig.Service.Path)
+	assert.Equal(map[string]string{comm.LabelEnabled: "true"}, validating.Webhooks[0].ObjectSelector.MatchLabels)
+
+	// Re-applying an unchanged configuration doesn't update it
+	resourceVersion := mutating.ResourceVersion
+	assert.Nil(configurator.EnsureConfigurations(ctx))
+	assert.Nil(configurator.Get(ctx, mutatingKey, mutating))
+	assert.Equal(resourceVersion, mutating.ResourceVersion)
+}
+
+func TestEnsureConfigurationsPreservesCABundle(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	configurator := newConfigurator(t, []byte("injected"))
+	assert.Nil(configurator.EnsureConfigurations(ctx))
+
+	// Without a CA certificate of its own, the caBundle injected by another party is kept
+	_ = os.Remove(configurator.CAFile)
+	configurator.FailurePolicy = admissionregistrationv1.Fail
+	assert.Nil(configurator.EnsureConfigurations(ctx))
+
+	mutating := &admissionregistrationv1.MutatingWebhookConfiguration{}
+	assert.Nil(configurator.Get(ctx, client.ObjectKey{Name: MutatingConfigurationName(configurator.Name)}, mutating))
+	assert.Equal([]byte("injected"), mutating.Webhooks[0].ClientConfig.CABundle)
+	assert.Equal(admissionregistrationv1.Fail, *mutating.Webhooks[0].FailurePolicy)
+}
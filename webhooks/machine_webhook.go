@@ -0,0 +1,149 @@
+/*
+Copyright 2021 Ales Nosek.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	comm "github.com/noseka1/gitops-friendly-machinesets-operator/common"
+	"github.com/noseka1/gitops-friendly-machinesets-operator/resolvers"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// The Machines are created by machine-api. The webhook must not block them if it is unavailable, the
+// Machine controller deletes the Machines that still contain tokens.
+//+kubebuilder:webhook:path=/mutate-machine-openshift-io-v1beta1-machine,mutating=true,failurePolicy=ignore,sideEffects=None,groups=machine.openshift.io,resources=machines,verbs=create,versions=v1beta1,name=machine.gitops-friendly-machinesets.kb.io,admissionReviewVersions={v1,v1beta1}
+
+const (
+	machineWebhookPath string = "/mutate-machine-openshift-io-v1beta1-machine"
+)
+
+// MachineWebhook replaces the tokens in Machines created from a MachineSet whose tokens haven't been
+// replaced yet. Such Machines are born correct and don't have to be deleted by the Machine controller.
+type MachineWebhook struct {
+	client      client.Client
+	decoder     *admission.Decoder
+	ClusterInfo *comm.ClusterInfo
+	Resolvers   *resolvers.TokenResolverRegistry
+}
+
+// SetupWithManager sets up the webhook with the Manager.
+func (m *MachineWebhook) SetupWithManager(mgr ctrl.Manager) {
+	webhookServer := mgr.GetWebhookServer()
+	webhookServer.Register(machineWebhookPath, &webhook.Admission{Handler: m})
+}
+
+// A client will be automatically injected.
+func (m *MachineWebhook) InjectClient(c client.Client) error {
+	m.client = c
+	return nil
+}
+
+// A decoder will be automatically injected.
+func (m *MachineWebhook) InjectDecoder(decoder *admission.Decoder) error {
+	m.decoder = decoder
+	return nil
+}
+
+// Replace tokens in Machine object
+func (m *MachineWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	logger := log.FromContext(ctx).WithName("webhook.machine").WithValues(
+		comm.FieldNamespace, req.Namespace, comm.FieldName, req.Name)
+
+	if isDryRun(req) {
+		logger = logger.WithValues("dryRun", true)
+	}
+
+	logger.V(2).Info("Called for object.")
+
+	// Parse the Machine object
+	machine := &unstructured.Unstructured{}
+	err := m.decoder.Decode(req, machine)
+	if err != nil {
+		logger.Error(err, "Failed to decode the Machine object.")
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	// The Machine inherits the settings of the MachineSet that owns it
+	owner, err := comm.GetOwnerMachineSet(ctx, logger, m.client, machine)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	settings := comm.InheritControlAnnotations(machine, owner)
+
+	// Is this object enabled for reconciliation?
+	enabled, tokenName := comm.EvaluateAnnotations(logger, settings)
+	if !enabled {
+		return admission.Allowed("")
+	}
+
+	// Compute the JSON patch
+	tokens, err := comm.ResolveTokens(ctx, logger, m.client, settings, tokenName, m.ClusterInfo, m.Resolvers)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	section, err := comm.SubstituteTokens(logger, settings, tokens, m.ClusterInfo)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	// The inherited annotations are not added to the Machine
+	annotations := section.GetAnnotations()
+	for key := range annotations {
+		if _, found := machine.GetAnnotations()[key]; !found {
+			delete(annotations, key)
+		}
+	}
+	section.SetAnnotations(annotations)
+	// The labels matched by the selector of the owner MachineSet are left untouched
+	labels := section.GetLabels()
+	for _, key := range comm.SelectorLabelKeys(owner) {
+		if value, found := machine.GetLabels()[key]; found {
+			labels[key] = value
+		}
+	}
+	section.SetLabels(labels)
+	machinePatchBytes, err := comm.CreateSectionsPatch(logger, machine, section)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	// Nothing to patch
+	if len(machinePatchBytes) == 0 {
+		return admission.Allowed("")
+	}
+
+	logger.Info("Tokens \"" + strings.Join(comm.TokenNames(tokens), ", ") + "\" in Machine replaced successfully.")
+
+	return admission.Response{
+		AdmissionResponse: admissionv1.AdmissionResponse{
+			Allowed: true,
+			Patch:   machinePatchBytes,
+			PatchType: func() *admissionv1.PatchType {
+				pt := admissionv1.PatchTypeJSONPatch
+				return &pt
+			}(),
+		},
+	}
+}
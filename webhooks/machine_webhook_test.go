@@ -0,0 +1,139 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	comm "github.com/noseka1/gitops-friendly-machinesets-operator/common"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	configapi "github.com/openshift/api/config/v1"
+	machineapi "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestMachineSelectorLabelsUntouched(t *testing.T) {
+	assert := assert.New(t)
+
+	scheme := runtime.NewScheme()
+	_ = machineapi.Install(scheme)
+	decoder, err := admission.NewDecoder(scheme)
+	assert.Nil(err)
+
+	// The owner MachineSet selects its Machines using a label that contains a token
+	owner := &machineapi.MachineSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "worker",
+			Namespace:   "openshift-machine-api",
+			UID:         "1234",
+			Annotations: map[string]string{comm.AnnotationEnabled: "true"},
+		},
+		Spec: machineapi.MachineSetSpec{
+			Selector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"machine.openshift.io/cluster-api-cluster": "INFRANAME"},
+			},
+		},
+	}
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(owner).Build()
+
+	m := &MachineWebhook{
+		ClusterInfo: &comm.ClusterInfo{
+			Infrastructure: configapi.InfrastructureStatus{InfrastructureName: "cluster-test-xyz"},
+		},
+		Resolvers: comm.NewDefaultTokenResolverRegistry(),
+	}
+	assert.Nil(m.InjectClient(fakeClient))
+	assert.Nil(m.InjectDecoder(decoder))
+
+	controller := true
+	machine := &machineapi.Machine{
+		TypeMeta: metav1.TypeMeta{APIVersion: "machine.openshift.io/v1beta1", Kind: "Machine"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "worker-abcde",
+			Namespace: "openshift-machine-api",
+			Labels: map[string]string{
+				"machine.openshift.io/cluster-api-cluster": "INFRANAME",
+				"example.com/cluster":                      "INFRANAME",
+			},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "machine.openshift.io/v1beta1",
+				Kind:       "MachineSet",
+				Name:       owner.Name,
+				UID:        owner.UID,
+				Controller: &controller,
+			}},
+		},
+	}
+	raw, err := json.Marshal(machine)
+	assert.Nil(err)
+	response := m.Handle(context.TODO(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Name:      machine.Name,
+		Namespace: machine.Namespace,
+		Object:    runtime.RawExtension{Raw: raw},
+	}})
+
+	assert.True(response.Allowed)
+	assert.Contains(string(response.Patch), "example.com~1cluster")
+	assert.NotContains(string(response.Patch), "cluster-api-cluster")
+}
+
+var _ = Describe("Machine webhook", func() {
+
+	Context("When Machine is created with unresolved tokens", func() {
+		It("Should resolve the tokens", func() {
+			By("Defining a Machine with unresolved tokens")
+			machine := &machineapi.Machine{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: "machine.openshift.io/v1beta1",
+					Kind:       "Machine",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "machine1",
+					Namespace: "openshift-machine-api",
+					Annotations: map[string]string{
+						"gitops-friendly-machinesets.redhat-cop.io/enabled": "true"},
+					Labels: map[string]string{
+						"machine.openshift.io/cluster-api-cluster": "INFRANAME",
+					},
+				},
+			}
+			By("Creating a Machine with unresolved tokens in Kubernetes")
+			err := k8sClient.Create(ctx, machine, &client.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			By("Checking that tokens have been resolved")
+			Expect(machine.GetLabels()["machine.openshift.io/cluster-api-cluster"]).To(Equal("cluster-test-xyz"))
+		})
+	})
+
+	Context("When Machine has unresolved tokens but reconciliation is disabled", func() {
+		It("Should NOT resolve the tokens", func() {
+			By("Defining a Machine with unresolved tokens and reconciliation disabled")
+			machine := &machineapi.Machine{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: "machine.openshift.io/v1beta1",
+					Kind:       "Machine",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "machine2",
+					Namespace: "openshift-machine-api",
+					Labels: map[string]string{
+						"machine.openshift.io/cluster-api-cluster": "INFRANAME",
+					},
+				},
+			}
+			By("Creating a Machine with unresolved tokens and reconciliation disabled in Kubernetes")
+			err := k8sClient.Create(ctx, machine, &client.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			By("Checking that tokens have NOT been resolved")
+			Expect(machine.GetLabels()["machine.openshift.io/cluster-api-cluster"]).To(Equal("INFRANAME"))
+		})
+	})
+})
@@ -0,0 +1,116 @@
+/*
+Copyright 2021 Ales Nosek.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"net/http"
+
+	comm "github.com/noseka1/gitops-friendly-machinesets-operator/common"
+	"github.com/noseka1/gitops-friendly-machinesets-operator/resolvers"
+	admissionv1 "k8s.io/api/admission/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+//+kubebuilder:webhook:path=/validate-machine-openshift-io-v1beta1-machineset,mutating=false,failurePolicy=fail,sideEffects=None,groups=machine.openshift.io,resources=machinesets,verbs=create;update,versions=v1beta1,name=validate.gitops-friendly-machinesets.kb.io,admissionReviewVersions={v1,v1beta1}
+
+const (
+	validatingWebhookPath string = "/validate-machine-openshift-io-v1beta1-machineset"
+)
+
+type MachineSetValidator struct {
+	client      client.Client
+	decoder     *admission.Decoder
+	ClusterInfo *comm.ClusterInfo
+	Resolvers   *resolvers.TokenResolverRegistry
+}
+
+// SetupWithManager sets up the webhook with the Manager.
+func (v *MachineSetValidator) SetupWithManager(mgr ctrl.Manager) {
+	webhookServer := mgr.GetWebhookServer()
+	webhookServer.Register(validatingWebhookPath, &webhook.Admission{Handler: v})
+}
+
+// A client will be automatically injected.
+func (v *MachineSetValidator) InjectClient(c client.Client) error {
+	v.client = c
+	return nil
+}
+
+// A decoder will be automatically injected.
+func (v *MachineSetValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.decoder = decoder
+	return nil
+}
+
+// Reject MachineSets that were not mutated correctly
+func (v *MachineSetValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	logger := log.FromContext(ctx).WithName("webhook.machineset.validate").WithValues(
+		comm.FieldNamespace, req.Namespace, comm.FieldName, req.Name)
+
+	if isDryRun(req) {
+		logger = logger.WithValues("dryRun", true)
+	}
+
+	logger.V(2).Info("Called for object.")
+
+	// Parse the MachineSet object
+	machineSet := &unstructured.Unstructured{}
+	err := v.decoder.Decode(req, machineSet)
+	if err != nil {
+		logger.Error(err, "Failed to decode the MachineSet object.")
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	// Is this object enabled for reconciliation?
+	enabled, tokenName := comm.EvaluateAnnotations(logger, machineSet)
+	if !enabled {
+		return admission.Allowed("")
+	}
+
+	tokens, err := comm.ResolveTokens(ctx, logger, v.client, machineSet, tokenName, v.ClusterInfo, v.Resolvers)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	section := comm.ExtractObjectSections(machineSet)
+	errs := comm.ValidateMachineSet(section, comm.NewSubstitution(logger, machineSet, tokens))
+	if len(errs) > 0 {
+		logger.Info("MachineSet is invalid: " + errs.ToAggregate().Error())
+		return invalidResponse(machineSet, errs)
+	}
+
+	return admission.Allowed("")
+}
+
+// Deny the request with the field-level errors
+func invalidResponse(obj *unstructured.Unstructured, errs field.ErrorList) admission.Response {
+	status := apierrors.NewInvalid(obj.GroupVersionKind().GroupKind(), obj.GetName(), errs).ErrStatus
+	return admission.Response{
+		AdmissionResponse: admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result:  &status,
+		},
+	}
+}
@@ -19,11 +19,14 @@ package webhooks
 import (
 	"context"
 	"net/http"
+	"strings"
 
 	comm "github.com/noseka1/gitops-friendly-machinesets-operator/common"
+	"github.com/noseka1/gitops-friendly-machinesets-operator/resolvers"
 	admissionv1 "k8s.io/api/admission/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -36,8 +39,12 @@ const (
 )
 
 type MachineSetWebhook struct {
-	decoder            *admission.Decoder
-	InfrastructureName string
+	client      client.Client
+	apiReader   client.Reader
+	streams     comm.CoreOSStreamCache
+	decoder     *admission.Decoder
+	ClusterInfo *comm.ClusterInfo
+	Resolvers   *resolvers.TokenResolverRegistry
 }
 
 // SetupWithManager sets up the webhook with the Manager.
@@ -46,6 +53,19 @@ func (m *MachineSetWebhook) SetupWithManager(mgr ctrl.Manager) {
 	webhookServer.Register(webhookPath, &webhook.Admission{Handler: m})
 }
 
+// A client will be automatically injected.
+func (m *MachineSetWebhook) InjectClient(c client.Client) error {
+	m.client = c
+	return nil
+}
+
+// An uncached reader will be automatically injected. It is used to read objects from namespaces
+// that are not cached by the manager.
+func (m *MachineSetWebhook) InjectAPIReader(reader client.Reader) error {
+	m.apiReader = reader
+	return nil
+}
+
 // A decoder will be automatically injected.
 func (m *MachineSetWebhook) InjectDecoder(decoder *admission.Decoder) error {
 	m.decoder = decoder
@@ -57,6 +77,12 @@ func (m *MachineSetWebhook) Handle(ctx context.Context, req admission.Request) a
 	logger := log.FromContext(ctx).WithName("webhook.machineset").WithValues(
 		comm.FieldNamespace, req.Namespace, comm.FieldName, req.Name)
 
+	// Dry-run requests must not cause any side effects, mark them in the logs
+	dryRun := isDryRun(req)
+	if dryRun {
+		logger = logger.WithValues("dryRun", true)
+	}
+
 	logger.V(2).Info("Called for object.")
 
 	// Parse the MachineSet object
@@ -70,21 +96,69 @@ func (m *MachineSetWebhook) Handle(ctx context.Context, req admission.Request) a
 	// Is this object enabled for reconciliation?
 	enabled, tokenName := comm.EvaluateAnnotations(logger, machineSet)
 	if !enabled {
+		if warning := comm.EnabledAnnotationWarning(machineSet); warning != "" {
+			return admission.Allowed("").WithWarnings(warning)
+		}
 		return admission.Allowed("")
 	}
 
 	// Compute the JSON patch
-	machineSetPatchBytes, err := comm.CreatePatch(logger, machineSet, tokenName, m.InfrastructureName)
+	tokens, err := comm.ResolveTokens(ctx, logger, m.client, machineSet, tokenName, m.ClusterInfo, m.Resolvers)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	warnings := comm.TokenWarnings(machineSet, tokenName, tokens)
+	for _, warning := range warnings {
+		logger.Info("Warning: " + warning)
+	}
+
+	// Reject the MachineSet if the templates would consume the tokens
+	if errs := comm.ValidateTokenSyntax(machineSet); len(errs) > 0 {
+		logger.Info("MachineSet token syntax is invalid: " + errs.ToAggregate().Error())
+		return invalidResponse(machineSet, errs).WithWarnings(warnings...)
+	}
+
+	section, err := comm.SubstituteTokens(logger, machineSet, tokens, m.ClusterInfo)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	// Add the missing machine-api labels. The selector of an existing MachineSet is left unchanged
+	comm.DefaultMachineAPILabels(logger, section, machineSet.GetName(), m.ClusterInfo.InfrastructureName(),
+		req.Operation == admissionv1.Create)
+
+	// Fill in the fields inherited from the installer-provisioned MachineSet
+	if req.Operation == admissionv1.Create && len(comm.ParseInheritFields(machineSet)) > 0 {
+		installerMachineSets, err := comm.ListInstallerMachineSets(ctx, logger, m.client, m.ClusterInfo.InfrastructureName())
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		comm.InheritInstallerFields(logger, machineSet, section, installerMachineSets)
+	}
+
+	// Fill in the RHCOS boot image
+	err = comm.ResolveBootImage(ctx, logger, &m.streams, m.apiReader, machineSet, section, m.ClusterInfo)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	// Reject the MachineSet if the token values produced invalid labels
+	if errs := comm.ValidateLabels(section); len(errs) > 0 {
+		logger.Info("MachineSet labels are invalid after token replacement: " + errs.ToAggregate().Error())
+		return invalidResponse(machineSet, errs).WithWarnings(warnings...)
+	}
+
+	machineSetPatchBytes, err := comm.CreateSectionsPatch(logger, machineSet, section)
 	if err != nil {
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
 
 	// Nothing to patch
 	if len(machineSetPatchBytes) == 0 {
-		return admission.Allowed("")
+		return admission.Allowed("").WithWarnings(warnings...)
 	}
 
-	logger.Info("Tokens \"" + tokenName + "\" in MachineSet replaced successfully.")
+	logger.Info("Tokens \"" + strings.Join(comm.TokenNames(tokens), ", ") + "\" in MachineSet replaced successfully.")
 
 	return admission.Response{
 		AdmissionResponse: admissionv1.AdmissionResponse{
@@ -94,6 +168,11 @@ func (m *MachineSetWebhook) Handle(ctx context.Context, req admission.Request) a
 				pt := admissionv1.PatchTypeJSONPatch
 				return &pt
 			}(),
+			Warnings: warnings,
 		},
 	}
 }
+
+func isDryRun(req admission.Request) bool {
+	return req.DryRun != nil && *req.DryRun
+}
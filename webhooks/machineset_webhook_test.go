@@ -1,14 +1,68 @@
 package webhooks
 
 import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	comm "github.com/noseka1/gitops-friendly-machinesets-operator/common"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	configapi "github.com/openshift/api/config/v1"
 	machineapi "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
+func TestRejectedMachineSetKeepsWarnings(t *testing.T) {
+	assert := assert.New(t)
+
+	scheme := runtime.NewScheme()
+	_ = machineapi.Install(scheme)
+	decoder, err := admission.NewDecoder(scheme)
+	assert.Nil(err)
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	m := &MachineSetWebhook{
+		ClusterInfo: &comm.ClusterInfo{
+			Infrastructure: configapi.InfrastructureStatus{InfrastructureName: "cluster-test-xyz"},
+		},
+		Resolvers: comm.NewDefaultTokenResolverRegistry(),
+	}
+	assert.Nil(m.InjectClient(fakeClient))
+	assert.Nil(m.InjectAPIReader(fakeClient))
+	assert.Nil(m.InjectDecoder(decoder))
+
+	// The token in the name produces a warning, the token in the label an invalid label value
+	machineSet := &machineapi.MachineSet{
+		TypeMeta: metav1.TypeMeta{APIVersion: "machine.openshift.io/v1beta1", Kind: "MachineSet"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "INFRANAME-worker",
+			Namespace:   "openshift-machine-api",
+			Annotations: map[string]string{comm.AnnotationEnabled: "true"},
+			Labels:      map[string]string{"example.com/long": "INFRANAME-INFRANAME-INFRANAME-INFRANAME"},
+		},
+	}
+	raw, err := json.Marshal(machineSet)
+	assert.Nil(err)
+	response := m.Handle(context.TODO(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Name:      machineSet.Name,
+		Namespace: machineSet.Namespace,
+		Object:    runtime.RawExtension{Raw: raw},
+	}})
+
+	assert.False(response.Allowed)
+	assert.Contains(response.Result.Message, "metadata.labels[example.com/long]")
+	assert.Equal([]string{"tokens in metadata.name are not replaced"}, response.Warnings)
+}
+
 var _ = Describe("MachineSet controller", func() {
 
 	Context("When MachineSet has unresolved tokens", func() {
@@ -28,6 +82,15 @@ var _ = Describe("MachineSet controller", func() {
 						"machine.openshift.io/cluster-api-cluster": "INFRANAME",
 					},
 				},
+				Spec: machineapi.MachineSetSpec{
+					Template: machineapi.MachineTemplateSpec{
+						ObjectMeta: machineapi.ObjectMeta{
+							Labels: map[string]string{
+								"machine.openshift.io/cluster-api-machine-role": "worker",
+							},
+						},
+					},
+				},
 			}
 			By("Creating a MachineSet with unresolved tokens in Kubernetes")
 			err := k8sClient.Create(ctx, machineSet, &client.CreateOptions{})
@@ -74,4 +137,125 @@ var _ = Describe("MachineSet controller", func() {
 			Expect(value).To(Equal("INFRANAME"))
 		})
 	})
+
+	Context("When MachineSet labels are invalid after replacing the tokens", func() {
+		It("Should reject the MachineSet", func() {
+			By("Defining a MachineSet with a token that results in an invalid label value")
+			machineSet := &machineapi.MachineSet{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: "machine.openshift.io/v1beta1",
+					Kind:       "MachineSet",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "machineset3",
+					Namespace: "openshift-machine-api",
+					Annotations: map[string]string{
+						"gitops-friendly-machinesets.redhat-cop.io/enabled": "true"},
+					Labels: map[string]string{
+						"example.com/long": "INFRANAME-INFRANAME-INFRANAME-INFRANAME",
+					},
+				},
+			}
+			By("Creating the MachineSet in Kubernetes")
+			err := k8sClient.Create(ctx, machineSet, &client.CreateOptions{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("metadata.labels[example.com/long]"))
+		})
+	})
+
+	Context("When MachineSet is missing the machine-api labels", func() {
+		It("Should add the missing labels", func() {
+			By("Defining a MachineSet with the role label only")
+			machineSet := &machineapi.MachineSet{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: "machine.openshift.io/v1beta1",
+					Kind:       "MachineSet",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "machineset4",
+					Namespace: "openshift-machine-api",
+					Annotations: map[string]string{
+						"gitops-friendly-machinesets.redhat-cop.io/enabled": "true"},
+				},
+				Spec: machineapi.MachineSetSpec{
+					Template: machineapi.MachineTemplateSpec{
+						ObjectMeta: machineapi.ObjectMeta{
+							Labels: map[string]string{
+								"machine.openshift.io/cluster-api-machine-role": "worker",
+							},
+						},
+					},
+				},
+			}
+			By("Creating the MachineSet in Kubernetes")
+			err := k8sClient.Create(ctx, machineSet, &client.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(func() error {
+				return k8sClient.Get(ctx,
+					types.NamespacedName{Namespace: machineSet.GetNamespace(), Name: machineSet.GetName()},
+					machineSet)
+			}).ShouldNot(HaveOccurred())
+			By("Checking that the labels have been added")
+			Expect(machineSet.GetLabels()).To(HaveKeyWithValue("machine.openshift.io/cluster-api-cluster", "cluster-test-xyz"))
+			Expect(machineSet.Spec.Selector.MatchLabels).To(HaveKeyWithValue("machine.openshift.io/cluster-api-machineset", "machineset4"))
+			Expect(machineSet.Spec.Template.Labels).To(HaveKeyWithValue("machine.openshift.io/cluster-api-machine-type", "worker"))
+		})
+	})
+
+	Context("When MachineSet selector does not match the template labels", func() {
+		It("Should reject the MachineSet", func() {
+			By("Defining a MachineSet with a mismatching selector")
+			machineSet := &machineapi.MachineSet{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: "machine.openshift.io/v1beta1",
+					Kind:       "MachineSet",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "machineset5",
+					Namespace: "openshift-machine-api",
+					Annotations: map[string]string{
+						"gitops-friendly-machinesets.redhat-cop.io/enabled": "true"},
+				},
+				Spec: machineapi.MachineSetSpec{
+					Selector: metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "foo"},
+					},
+					Template: machineapi.MachineTemplateSpec{
+						ObjectMeta: machineapi.ObjectMeta{
+							Labels: map[string]string{
+								"machine.openshift.io/cluster-api-machine-role": "worker",
+								"app": "bar",
+							},
+						},
+					},
+				},
+			}
+			By("Creating the MachineSet in Kubernetes")
+			err := k8sClient.Create(ctx, machineSet, &client.CreateOptions{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.template.metadata.labels"))
+		})
+	})
+
+	Context("When MachineSet template has no role label", func() {
+		It("Should reject the MachineSet", func() {
+			By("Defining a MachineSet without the role label")
+			machineSet := &machineapi.MachineSet{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: "machine.openshift.io/v1beta1",
+					Kind:       "MachineSet",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "machineset6",
+					Namespace: "openshift-machine-api",
+					Annotations: map[string]string{
+						"gitops-friendly-machinesets.redhat-cop.io/enabled": "true"},
+				},
+			}
+			By("Creating the MachineSet in Kubernetes")
+			err := k8sClient.Create(ctx, machineSet, &client.CreateOptions{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("machine.openshift.io/cluster-api-machine-role"))
+		})
+	})
 })
@@ -38,6 +38,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	comm "github.com/noseka1/gitops-friendly-machinesets-operator/common"
+	configapi "github.com/openshift/api/config/v1"
 	machineapi "github.com/openshift/api/machine/v1beta1"
 	admissionv1 "k8s.io/api/admission/v1"
 	v1 "k8s.io/api/core/v1"
@@ -143,7 +145,26 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).NotTo(HaveOccurred())
 
-	(&MachineSetWebhook{InfrastructureName: "cluster-test-xyz"}).SetupWithManager(mgr)
+	(&MachineSetWebhook{
+		ClusterInfo: &comm.ClusterInfo{
+			Infrastructure: configapi.InfrastructureStatus{InfrastructureName: "cluster-test-xyz"},
+		},
+		Resolvers: comm.NewDefaultTokenResolverRegistry(),
+	}).SetupWithManager(mgr)
+
+	(&MachineSetValidator{
+		ClusterInfo: &comm.ClusterInfo{
+			Infrastructure: configapi.InfrastructureStatus{InfrastructureName: "cluster-test-xyz"},
+		},
+		Resolvers: comm.NewDefaultTokenResolverRegistry(),
+	}).SetupWithManager(mgr)
+
+	(&MachineWebhook{
+		ClusterInfo: &comm.ClusterInfo{
+			Infrastructure: configapi.InfrastructureStatus{InfrastructureName: "cluster-test-xyz"},
+		},
+		Resolvers: comm.NewDefaultTokenResolverRegistry(),
+	}).SetupWithManager(mgr)
 
 	//+kubebuilder:scaffold:webhook
 